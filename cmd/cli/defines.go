@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// values given with `-define NAME=JSON`
+type defineFlag map[string]string
+
+func (d defineFlag) String() string {
+	defines := []string{}
+	for name, value := range d {
+		defines = append(defines, name+"="+value)
+	}
+	return strings.Join(defines, " ")
+}
+
+func (d defineFlag) Set(value string) error {
+	name, value, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected NAME=JSON, got %s", value)
+	}
+	if !json.Valid([]byte(value)) {
+		return fmt.Errorf("the value of %s is not valid JSON: %s", name, value)
+	}
+	d[name] = value
+	return nil
+}
+
+// the configuration file given with `-config`
+type config struct {
+	Defines map[string]json.RawMessage `json:"defines"`
+}
+
+// adds the -define and -config flags, the returned function returns the
+// defines of the configuration file overridden by the ones of the flags
+func defineFlags(flags *flag.FlagSet) func() (map[string]string, error) {
+	defines := defineFlag{}
+	flags.Var(defines, "define", "Define a comptime binding with NAME=JSON, can be repeated.")
+	configPath := flags.String("config", "", "Read defines from a JSON configuration file: {\"defines\": {\"NAME\": value}}.")
+
+	return func() (map[string]string, error) {
+		merged := map[string]string{}
+		if *configPath != "" {
+			buff, err := os.ReadFile(*configPath)
+			if err != nil {
+				return nil, err
+			}
+			var c config
+			err = json.Unmarshal(buff, &c)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", *configPath, err)
+			}
+			for name, value := range c.Defines {
+				merged[name] = string(value)
+			}
+		}
+		for name, value := range defines {
+			merged[name] = value
+		}
+		return merged, nil
+	}
+}
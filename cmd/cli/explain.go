@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"jscomptime/lib/comptime"
+	"jscomptime/lib/jsenv"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+const (
+	ansi_reset   = "\x1b[0m"
+	ansi_dim     = "\x1b[2m"
+	ansi_green   = "\x1b[32m"
+	ansi_yellow  = "\x1b[33m"
+	ansi_magenta = "\x1b[35m"
+)
+
+type annotationKind = uint8
+
+const (
+	annotation_region annotationKind = iota
+	annotation_statement
+	annotation_declaration
+)
+
+type annotation struct {
+	kind  annotationKind
+	start uint32
+	end   uint32
+	// the position of the definition in Scope.DefinitionOrder, prefixed
+	// by the positions of the scopes containing it
+	label string
+	note  string
+}
+
+func (a annotation) className() string {
+	switch a.kind {
+	case annotation_statement:
+		return "ct-statement"
+	case annotation_declaration:
+		return "ct-declaration"
+	}
+	return "ct-region"
+}
+
+func (a annotation) color() string {
+	switch a.kind {
+	case annotation_statement:
+		return ansi_yellow
+	case annotation_declaration:
+		return ansi_magenta
+	}
+	return ansi_green
+}
+
+// returns the value on a single line
+func flatValue(value string) string {
+	return comptime.FormatValue(value, 0, "", comptime.Options{Width: math.MaxInt32})
+}
+
+func collectAnnotations(
+	scope *comptime.Scope,
+	prefix string,
+	inspection comptime.Inspection,
+	values map[[2]uint32]string,
+) []annotation {
+	annotations := []annotation{}
+	for i, ref := range scope.DefinitionOrder {
+		label := "#" + prefix + strconv.Itoa(i)
+		switch ref.Type {
+		case comptime.DEF_SCOPE:
+			annotations = append(annotations, collectAnnotations(
+				scope.Scopes[ref.Index],
+				prefix+strconv.Itoa(i)+".",
+				inspection,
+				values,
+			)...)
+		case comptime.DEF_COMPTIME_STATEMENT:
+			node := scope.ComptimeStatements[ref.Index].Parent()
+			annotations = append(annotations, annotation{
+				kind:  annotation_statement,
+				start: node.StartByte(),
+				end:   node.EndByte(),
+				label: label,
+				note:  "statement",
+			})
+		case comptime.DEF_COMPTIME_DECLARATION:
+			decl := scope.ComptimeDeclarations[ref.Index]
+			bindings := []string{}
+			for _, id := range decl.Identifiers {
+				value, ok := inspection.Bindings[decl.Node.StartByte()][id]
+				if !ok {
+					bindings = append(bindings, id)
+					continue
+				}
+				bindings = append(bindings, id+" = "+flatValue(value))
+			}
+			node := decl.Node.Parent()
+			annotations = append(annotations, annotation{
+				kind:  annotation_declaration,
+				start: node.StartByte(),
+				end:   node.EndByte(),
+				label: label,
+				note:  "declaration " + strings.Join(bindings, ", "),
+			})
+		case comptime.DEF_REGION, comptime.DEF_JSX_EXPANSION, comptime.DEF_MACRO:
+			var node *sitter.Node
+			note := "region = "
+			switch ref.Type {
+			case comptime.DEF_REGION:
+				node = scope.Regions[ref.Index]
+			case comptime.DEF_JSX_EXPANSION:
+				node = scope.JSXExpansions[ref.Index].Node
+			case comptime.DEF_MACRO:
+				node = scope.Macros[ref.Index].Node
+				note = "macro = "
+			}
+			value := values[[2]uint32{node.StartByte(), node.EndByte()}]
+			annotations = append(annotations, annotation{
+				kind:  annotation_region,
+				start: node.StartByte(),
+				end:   node.EndByte(),
+				label: label,
+				note:  note + flatValue(value),
+			})
+		}
+	}
+	return annotations
+}
+
+// writes the source with every annotation marked, annotations are written as
+// comments after the code they annotate
+func writeExplanation(out io.Writer, source []byte, annotations []annotation, color bool, asHTML bool) {
+	text := func(b []byte) string {
+		if asHTML {
+			return html.EscapeString(string(b))
+		}
+		return string(b)
+	}
+
+	if asHTML {
+		fmt.Fprint(out, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<style>
+.ct-region { background: #d7f5dd; }
+.ct-statement { background: #fbf1c7; }
+.ct-declaration { background: #f2dcf5; }
+.ct-note { color: #888; }
+</style>
+</head>
+<body>
+<pre>`)
+	}
+
+	cursor := uint32(0)
+	for _, a := range annotations {
+		if a.start < cursor {
+			continue
+		}
+		fmt.Fprint(out, text(source[cursor:a.start]))
+		note := fmt.Sprintf("/* %s %s */", a.label, a.note)
+		switch {
+		case asHTML:
+			fmt.Fprintf(
+				out, `<span class="%s" title="%s">%s</span> <span class="ct-note">%s</span>`,
+				a.className(), html.EscapeString(a.label),
+				text(source[a.start:a.end]), html.EscapeString(note),
+			)
+		case color:
+			fmt.Fprintf(
+				out, "%s%s%s %s%s%s",
+				a.color(), source[a.start:a.end], ansi_reset,
+				ansi_dim, note, ansi_reset,
+			)
+		default:
+			fmt.Fprintf(out, "%s %s", source[a.start:a.end], note)
+		}
+		cursor = a.end
+	}
+	fmt.Fprint(out, text(source[cursor:]))
+
+	if asHTML {
+		fmt.Fprint(out, "</pre>\n</body>\n</html>\n")
+	}
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// prints a source file with its comptime declarations, statements and
+// regions marked
+func explain(args []string) {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	asHTML := flags.Bool("html", false, "Write the explanation as an html document.")
+	color := flags.Bool("color", isTerminal(os.Stdout), "Color the output with ansi escape codes.")
+	dialectName := flags.String("dialect", "", "The language of the input (js, jsx, ts or tsx), inferred from the file extension by default.")
+	defines := defineFlags(flags)
+	flags.Parse(args)
+
+	filename := flags.Arg(0)
+	if filename == "" {
+		log.Fatal("explain: a file is required")
+	}
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defined, err := defines()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts := comptime.Options{
+		Dialect:  comptime.DialectFromPath(filename),
+		Filename: filename,
+		Defines:  defined,
+	}
+	if *dialectName != "" {
+		var ok bool
+		opts.Dialect, ok = comptime.ParseDialect(*dialectName)
+		if !ok {
+			log.Fatalf("unknown dialect: %s", *dialectName)
+		}
+	}
+
+	ctx := context.Background()
+	parsed, err := comptime.Parse(ctx, source, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	parsed, err = comptime.Normalize(ctx, parsed, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	analysis := comptime.Analyze(parsed, opts)
+	for _, d := range analysis.OriginalDiagnostics(analysis.Diagnostics) {
+		fmt.Fprintf(os.Stderr, "%s:%s\n", filename, d)
+	}
+	if comptime.HasErrors(analysis.Diagnostics) {
+		os.Exit(1)
+	}
+
+	// the output of comptime code would be mixed with the explanation
+	env := jsenv.Nodejs{Command: "node", Output: os.Stderr}
+	inspection, err := comptime.Inspect(ctx, analysis, env, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	values := map[[2]uint32]string{}
+	for _, r := range inspection.Regions {
+		values[[2]uint32{r.Node.StartByte(), r.Node.EndByte()}] = r.Value
+	}
+	annotations := collectAnnotations(analysis.Root, "", inspection, values)
+	// the annotations are written over the source before normalization
+	for i, a := range annotations {
+		annotations[i].start = uint32(analysis.OriginalOffset(int(a.start)))
+		annotations[i].end = uint32(analysis.OriginalOffset(int(a.end)))
+	}
+	sort.SliceStable(annotations, func(i, j int) bool {
+		return annotations[i].start < annotations[j].start
+	})
+
+	writeExplanation(os.Stdout, source, annotations, *color && !*asHTML, *asHTML)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteExplanation(t *testing.T) {
+	source := []byte("const a = f(1 + 2)")
+	annotations := []annotation{
+		{kind: annotation_region, start: 12, end: 17, label: "#0", note: "region = 3"},
+		// overlapping annotations are skipped
+		{kind: annotation_region, start: 14, end: 17, label: "#1", note: "region = 2"},
+	}
+
+	out := &bytes.Buffer{}
+	writeExplanation(out, source, annotations, false, false)
+	if expected := "const a = f(1 + 2 /* #0 region = 3 */)"; out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+
+	out.Reset()
+	writeExplanation(out, []byte("f(a < b)"), []annotation{{start: 2, end: 7, label: "#0", note: "region = true"}}, false, true)
+	if !bytes.Contains(out.Bytes(), []byte(`<span class="ct-region" title="#0">a &lt; b</span>`)) {
+		t.Errorf("unexpected html: %s", out.String())
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"jscomptime/lib/jsenv"
+	"jscomptime/lib/lsp"
+	"log"
+	"os"
+)
+
+// runs a language server over stdin and stdout
+func languageServer(args []string) {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	flags.Bool("stdio", true, "Communicate over stdin and stdout.")
+	flags.Parse(args)
+
+	// stdout is reserved for the protocol
+	log.SetOutput(os.Stderr)
+	env := &jsenv.NodejsWorker{
+		Command: "node",
+		Output:  os.Stderr,
+	}
+	defer env.Close()
+
+	server := lsp.NewServer(os.Stdin, os.Stdout, env)
+	err := server.Serve(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"jscomptime/lib/comptime"
@@ -19,19 +21,119 @@ a comptime value can only be brought into runtime code in only 2 ways:
 func main() {
 	log.SetFlags(log.Ltime | log.Lshortfile)
 
-	buff, err := io.ReadAll(os.Stdin)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			serve(os.Args[2:])
+			return
+		case "lsp":
+			languageServer(os.Args[2:])
+			return
+		case "explain":
+			explain(os.Args[2:])
+			return
+		case "why":
+			why(os.Args[2:])
+			return
+		}
+	}
+
+	dialectName := flag.String("dialect", "", "The language of the input (js, jsx, ts or tsx), inferred from the file extension by default.")
+	width := flag.Int("width", comptime.DEFAULT_WIDTH, "The column after which inlined values are wrapped.")
+	minify := flag.Bool("minify", false, "Minify inlined values and remove what is left behind by comptime statements.")
+	deadCode := flag.Bool("dce", false, "Remove the branches and operands made unreachable by constant conditions once values are inlined.")
+	hoistSize := flag.Int("hoist", 0, "Hoist inlined values longer than this many bytes into module-level constants shared by identical values, 0 disables hoisting.")
+	embedLimit := flag.Int("embed-limit", 0, "Fail the compilation when a file embedded with $comptime.embed is larger than this many bytes, 0 disables the limit.")
+	sourceMapPath := flag.String("sourcemap", "", "Write the source map of the output to this file.")
+	target := flag.String("target", "", "The environment the output is built for, reflected to comptime code as $comptime.target.")
+	root := flag.String("root", "", "The root of the project reflected to comptime code, defaults to the closest directory containing a package.json.")
+	dumpScopesPath := flag.String("dump-scopes", "", "Write the scope analysis of the input as JSON to this file.")
+	defines := defineFlags(flag.CommandLine)
+	flag.Parse()
+
+	// the source is read from the file given as an argument or stdin
+	filename := flag.Arg(0)
+	var buff []byte
+	var err error
+	if filename != "" {
+		buff, err = os.ReadFile(filename)
+	} else {
+		buff, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dialect := comptime.DialectFromPath(filename)
+	if *dialectName != "" {
+		var ok bool
+		dialect, ok = comptime.ParseDialect(*dialectName)
+		if !ok {
+			log.Fatalf("unknown dialect: %s", *dialectName)
+		}
+	}
+
+	defined, err := defines()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	opts := comptime.Options{
+		Dialect:    dialect,
+		Width:      *width,
+		Minify:     *minify,
+		DeadCode:   *deadCode,
+		HoistSize:  *hoistSize,
+		EmbedLimit: *embedLimit,
+		Filename:   filename,
+		Root:       *root,
+		Defines:    defined,
+		Target:     *target,
+	}
+
+	if *dumpScopesPath != "" {
+		parsed, err := comptime.Parse(context.Background(), buff, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		parsed, err = comptime.Normalize(context.Background(), parsed, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		analysis := comptime.Analyze(parsed, opts)
+		serialized, err := json.MarshalIndent(comptime.DumpScopes(analysis, opts), "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = os.WriteFile(*dumpScopesPath, serialized, 0666)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// stdout is reserved for the compiled code
 	env := jsenv.Nodejs{
 		Command: "node",
+		Output:  os.Stderr,
 	}
 
-	compiled, err := comptime.Compile(context.Background(), buff, env)
+	result, err := comptime.Compile(context.Background(), buff, env, opts)
 	if err != nil {
 		log.Fatal(err)
 	}
+	for _, d := range result.Diagnostics {
+		fmt.Fprintf(os.Stderr, "%s:%s\n", filename, d)
+	}
+	if comptime.HasErrors(result.Diagnostics) {
+		os.Exit(1)
+	}
+
+	if *sourceMapPath != "" {
+		err = os.WriteFile(*sourceMapPath, result.SourceMap, 0666)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	fmt.Println(compiled)
+	fmt.Println(result.Code)
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"jscomptime/lib/comptime"
+	"jscomptime/lib/jsenv"
+	"log"
+	"os"
+	"sync"
+)
+
+type serveRequest struct {
+	Id json.RawMessage `json:"id"`
+	// the path of the file, used to pick the dialect and resolve requires
+	Path string `json:"path"`
+	// the content of the file, it is read from path if omitted
+	Source *string `json:"source"`
+}
+
+type serveDiagnostic struct {
+	Severity string            `json:"severity"`
+	Message  string            `json:"message"`
+	Start    comptime.Position `json:"start"`
+	End      comptime.Position `json:"end"`
+}
+
+type serveResponse struct {
+	Id          json.RawMessage   `json:"id"`
+	Code        string            `json:"code,omitempty"`
+	Map         json.RawMessage   `json:"map,omitempty"`
+	Diagnostics []serveDiagnostic `json:"diagnostics"`
+	Deps        []string          `json:"deps"`
+	Error       string            `json:"error,omitempty"`
+}
+
+func severityName(severity comptime.Severity) string {
+	if severity == comptime.SEVERITY_WARNING {
+		return "warning"
+	}
+	return "error"
+}
+
+func handleServeRequest(
+	ctx context.Context,
+	env jsenv.Env,
+	opts comptime.Options,
+	request serveRequest,
+) serveResponse {
+	response := serveResponse{
+		Id:          request.Id,
+		Diagnostics: []serveDiagnostic{},
+		Deps:        []string{},
+	}
+
+	var source []byte
+	if request.Source != nil {
+		source = []byte(*request.Source)
+	} else {
+		var err error
+		source, err = os.ReadFile(request.Path)
+		if err != nil {
+			response.Error = err.Error()
+			return response
+		}
+	}
+
+	opts.Dialect = comptime.DialectFromPath(request.Path)
+	opts.Filename = request.Path
+	result, err := comptime.Compile(ctx, source, env, opts)
+	if err != nil {
+		response.Error = err.Error()
+		return response
+	}
+
+	for _, d := range result.Diagnostics {
+		response.Diagnostics = append(response.Diagnostics, serveDiagnostic{
+			Severity: severityName(d.Severity),
+			Message:  d.Message,
+			Start:    d.Start,
+			End:      d.End,
+		})
+	}
+	if result.Dependencies != nil {
+		response.Deps = result.Dependencies
+	}
+	if !comptime.HasErrors(result.Diagnostics) {
+		response.Code = result.Code
+		response.Map = result.SourceMap
+	}
+	return response
+}
+
+// reads json requests from stdin and writes a json response for each of them
+// to stdout, one per line
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	stdio := flags.Bool("stdio", false, "Communicate over stdin and stdout.")
+	width := flags.Int("width", comptime.DEFAULT_WIDTH, "The column after which inlined values are wrapped.")
+	minify := flags.Bool("minify", false, "Minify inlined values and remove what is left behind by comptime statements.")
+	deadCode := flags.Bool("dce", false, "Remove the branches and operands made unreachable by constant conditions once values are inlined.")
+	hoistSize := flags.Int("hoist", 0, "Hoist inlined values longer than this many bytes into module-level constants shared by identical values, 0 disables hoisting.")
+	embedLimit := flags.Int("embed-limit", 0, "Fail the compilation when a file embedded with $comptime.embed is larger than this many bytes, 0 disables the limit.")
+	target := flags.String("target", "", "The environment the output is built for, reflected to comptime code as $comptime.target.")
+	root := flags.String("root", "", "The root of the project reflected to comptime code, defaults to the closest directory containing a package.json.")
+	defines := defineFlags(flags)
+	flags.Parse(args)
+
+	if !*stdio {
+		log.Fatal("serve: only --stdio is supported")
+	}
+
+	defined, err := defines()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// stdout is reserved for responses
+	env := &jsenv.NodejsWorker{
+		Command: "node",
+		Output:  os.Stderr,
+	}
+	defer env.Close()
+
+	opts := comptime.Options{
+		Width:      *width,
+		Minify:     *minify,
+		DeadCode:   *deadCode,
+		HoistSize:  *hoistSize,
+		EmbedLimit: *embedLimit,
+		Root:       *root,
+		Defines:    defined,
+		Target:     *target,
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	writeLock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		request := serveRequest{}
+		err := json.Unmarshal(scanner.Bytes(), &request)
+		if err != nil {
+			writeLock.Lock()
+			encoder.Encode(serveResponse{Error: err.Error()})
+			writeLock.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response := handleServeRequest(context.Background(), env, opts, request)
+			writeLock.Lock()
+			defer writeLock.Unlock()
+			err := encoder.Encode(response)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
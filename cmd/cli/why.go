@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"jscomptime/lib/comptime"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// parses `file.js:LINE:COL`, lines and columns start at 1
+func parseLocation(location string) (string, int, int, error) {
+	parts := strings.Split(location, ":")
+	if len(parts) < 3 {
+		return "", 0, 0, fmt.Errorf("expected file:LINE:COL, got %s", location)
+	}
+	line, err := strconv.Atoi(parts[len(parts)-2])
+	if err != nil || line < 1 {
+		return "", 0, 0, fmt.Errorf("invalid line in %s", location)
+	}
+	col, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil || col < 1 {
+		return "", 0, 0, fmt.Errorf("invalid column in %s", location)
+	}
+	return strings.Join(parts[:len(parts)-2], ":"), line, col, nil
+}
+
+// converts a line and a column counted in characters to a tree-sitter point
+func sourcePoint(source []byte, line int, col int) sitter.Point {
+	lines := strings.Split(string(source), "\n")
+	point := sitter.Point{Row: uint32(line - 1)}
+	if line > len(lines) {
+		return point
+	}
+	text := lines[line-1]
+	for i := 1; i < col && len(text) > 0; i++ {
+		_, size := utf8.DecodeRuneInString(text)
+		text = text[size:]
+		point.Column += uint32(size)
+	}
+	return point
+}
+
+// returns the first line of a node, shortened
+func snippet(node *sitter.Node, source []byte) string {
+	text := node.Content(source)
+	shortened := false
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+		shortened = true
+	}
+	if utf8.RuneCountInString(text) > 40 {
+		text = string([]rune(text)[:40])
+		shortened = true
+	}
+	if shortened {
+		text += "…"
+	}
+	return text
+}
+
+// returns the position of a node in the source before normalization
+func position(analysis *comptime.Analysis, node *sitter.Node) string {
+	source := analysis.Original()
+	offset := analysis.OriginalOffset(int(node.StartByte()))
+	lineStart := bytes.LastIndexByte(source[:offset], '\n') + 1
+	line := bytes.Count(source[:lineStart], []byte("\n"))
+	return fmt.Sprintf("%d:%d", line+1, offset-lineStart+1)
+}
+
+// converts a point in the source before normalization to a point in the
+// source, normalization never inserts line breaks
+func normalizedPoint(analysis *comptime.Analysis, point sitter.Point) sitter.Point {
+	lines := bytes.SplitAfter(analysis.Original(), []byte("\n"))
+	lineStart := 0
+	for _, l := range lines[:min(int(point.Row), len(lines))] {
+		lineStart += len(l)
+	}
+	offset := analysis.NormalizedOffset(lineStart + int(point.Column))
+	point.Column = uint32(offset - analysis.NormalizedOffset(lineStart))
+	return point
+}
+
+func scopeName(analysis *comptime.Analysis, scope *comptime.Scope) string {
+	if scope.Node == nil {
+		return "scope"
+	}
+	return fmt.Sprintf("%s scope at %s", scope.Node.Type(), position(analysis, scope.Node))
+}
+
+// explains why the expression at a position is kept at runtime
+func why(args []string) {
+	flags := flag.NewFlagSet("why", flag.ExitOnError)
+	dialectName := flags.String("dialect", "", "The language of the input (js, jsx, ts or tsx), inferred from the file extension by default.")
+	defines := defineFlags(flags)
+	flags.Parse(args)
+
+	filename, line, col, err := parseLocation(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defined, err := defines()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts := comptime.Options{
+		Dialect:  comptime.DialectFromPath(filename),
+		Filename: filename,
+		Defines:  defined,
+	}
+	if *dialectName != "" {
+		var ok bool
+		opts.Dialect, ok = comptime.ParseDialect(*dialectName)
+		if !ok {
+			log.Fatalf("unknown dialect: %s", *dialectName)
+		}
+	}
+
+	parsed, err := comptime.Parse(context.Background(), source, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	parsed, err = comptime.Normalize(context.Background(), parsed, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	analysis := comptime.Analyze(parsed, opts)
+	for _, d := range analysis.OriginalDiagnostics(analysis.Diagnostics) {
+		fmt.Fprintf(os.Stderr, "%s:%s\n", filename, d)
+	}
+	if comptime.HasErrors(analysis.Diagnostics) {
+		os.Exit(1)
+	}
+
+	w, err := comptime.WhyAt(analysis, normalizedPoint(analysis, sourcePoint(source, line, col)))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// snippets are taken from the normalized source the nodes belong to
+	source = analysis.Source
+	expr := w.Expression
+	if w.Resolution == comptime.RESOLUTION_COMPTIME {
+		fmt.Printf("%s:%s: `%s` is evaluated at comptime\n", filename, position(analysis, expr), snippet(expr, source))
+		return
+	}
+
+	if w.Folded != nil {
+		fmt.Printf("%s:%s: `%s` is evaluated at comptime\n", filename, position(analysis, w.Folded), snippet(w.Folded, source))
+	}
+	fmt.Printf("%s:%s: `%s` stays at runtime\n", filename, position(analysis, expr), snippet(expr, source))
+	for _, node := range w.Path {
+		fmt.Printf("  %s `%s` %s\n", node.Type(), snippet(node, source), position(analysis, node))
+	}
+
+	blocker := snippet(w.Blocker, source)
+	switch w.Resolution {
+	case comptime.RESOLUTION_PARAMETER:
+		fmt.Printf("`%s` is a parameter of the %s\n", blocker, scopeName(analysis, w.Scope))
+	case comptime.RESOLUTION_RUNTIME_DECLARATION:
+		fmt.Printf("`%s` is declared at runtime in the %s\n", blocker, scopeName(analysis, w.Scope))
+	case comptime.RESOLUTION_GLOBAL:
+		fmt.Printf("`%s` is not declared in this file, it is resolved as a runtime global\n", blocker)
+	case comptime.RESOLUTION_NOT_FOLDABLE:
+		fmt.Printf("%s expressions are never evaluated at comptime\n", w.Blocker.Type())
+	}
+	if w.Shadowed != nil {
+		fmt.Printf(
+			"it shadows the comptime declaration at %s\n",
+			position(analysis, w.Shadowed.Node),
+		)
+	}
+}
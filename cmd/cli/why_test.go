@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"jscomptime/lib/comptime"
+	"testing"
+)
+
+// parses, normalizes and analyzes a source like the commands do
+func analyze(t *testing.T, source string) *comptime.Analysis {
+	t.Helper()
+	ctx := context.Background()
+	opts := comptime.Options{}
+	parsed, err := comptime.Parse(ctx, []byte(source), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err = comptime.Normalize(ctx, parsed, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return comptime.Analyze(parsed, opts)
+}
+
+func TestParseLocation(t *testing.T) {
+	filename, line, col, err := parseLocation("dir/a:b.js:3:14")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filename != "dir/a:b.js" || line != 3 || col != 14 {
+		t.Errorf("got %s %d %d", filename, line, col)
+	}
+
+	for _, location := range []string{"a.js", "a.js:0:1", "a.js:1:x"} {
+		if _, _, _, err := parseLocation(location); err == nil {
+			t.Errorf("expected an error for %s", location)
+		}
+	}
+}
+
+func TestWhyNormalized(t *testing.T) {
+	source := "$comptime: var port = 1\nconst o = { port }, y = window.x"
+	analysis := analyze(t, source)
+
+	// `window` is after the expanded shorthand property on the same line
+	w, err := comptime.WhyAt(analysis, normalizedPoint(analysis, sourcePoint([]byte(source), 2, 25)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := snippet(w.Expression, analysis.Source); got != "window" {
+		t.Errorf("expected `window`, got `%s`", got)
+	}
+	if got := position(analysis, w.Expression); got != "2:25" {
+		t.Errorf("expected 2:25, got %s", got)
+	}
+	if w.Resolution != comptime.RESOLUTION_GLOBAL {
+		t.Errorf("expected a global, got %d", w.Resolution)
+	}
+}
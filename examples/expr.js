@@ -1,6 +1,6 @@
 $comptime: const comptimeVar = 24
 $comptime: function comptimeFunction(a, b) {
-  return n ** 2
+  return a ** 2
 }
 $comptime: const comptimeKey = "foo"
 
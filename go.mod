@@ -2,6 +2,9 @@ module jscomptime
 
 go 1.21.1
 
-require github.com/smacker/go-tree-sitter v0.0.0-20231219031718-233c2f923ac7
+require (
+	github.com/evanw/esbuild v0.19.11
+	github.com/smacker/go-tree-sitter v0.0.0-20231219031718-233c2f923ac7
+)
 
-require github.com/deckarep/golang-set/v2 v2.6.0 // indirect
+require golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
@@ -0,0 +1,143 @@
+package comptime
+
+import (
+	"context"
+	"jscomptime/lib/jsenv"
+	"slices"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// the syntax tree of a source file
+type Parsed struct {
+	Source []byte
+	Tree   *sitter.Tree
+	// syntax errors
+	Diagnostics []Diagnostic
+	// the source before it was normalized and the text inserted by the
+	// normalization, nil if the source was not normalized
+	original   []byte
+	insertions []insertion
+}
+
+// parses the source, this is the first pass of a compilation
+func Parse(ctx context.Context, source []byte, opts Options) (*Parsed, error) {
+	parser := sitter.NewParser()
+	parser.SetLanguage(opts.language())
+	tree, err := parser.ParseCtx(ctx, nil, source)
+	if err != nil {
+		return nil, err
+	}
+	return &Parsed{
+		Source:      source,
+		Tree:        tree,
+		Diagnostics: syntaxDiagnostics(tree.RootNode(), source),
+	}, nil
+}
+
+// the result of analyzing a source file without evaluating it
+type Analysis struct {
+	*Parsed
+	// the scope of the whole file, it is empty if the source has syntax
+	// errors
+	Root *Scope
+}
+
+// builds the scope tree of a parsed source
+func Analyze(parsed *Parsed, opts Options) *Analysis {
+	analysis := &Analysis{
+		Parsed: parsed,
+		Root: &Scope{
+			Node:    parsed.Tree.RootNode(),
+			Defines: defineNames(opts),
+		},
+	}
+	if HasErrors(parsed.Diagnostics) {
+		return analysis
+	}
+	root := parsed.Tree.RootNode()
+	analysis.Root.RuntimeDeclarations = scopeBindings(root, parsed.Source)
+	analysis.Root.ComptimeIdentifiers = labelBindings(root, parsed.Source, COMPTIME_KEYWORD)
+	analysis.Root.MacroIdentifiers = labelBindings(root, parsed.Source, MACRO_KEYWORD)
+	recurse(root, analysis.Root, parsed.Source)
+
+	diagnostics := orderEvaluation(analysis.Root, parsed.Source)
+	if len(diagnostics) > 0 {
+		// the parsed source is left as it is
+		withOrder := *parsed
+		withOrder.Diagnostics = append(slices.Clip(parsed.Diagnostics), diagnostics...)
+		analysis.Parsed = &withOrder
+	}
+	return analysis
+}
+
+// the evaluated value of a region or a comptime binding, values are
+// serialized as javascript
+type InspectedValue struct {
+	Node  *sitter.Node
+	Value string
+}
+
+type Inspection struct {
+	// regions, jsx expansions and macros in the order they are defined, the
+	// value of a jsx expansion is the array it expands and the value of a
+	// macro is the code it expands to
+	Regions []InspectedValue
+	// the values of comptime bindings, indexed by the start byte of their
+	// declaration and then by name
+	Bindings map[uint32]map[string]string
+	// absolute paths of the files read by comptime code
+	Dependencies []string
+}
+
+// evaluates every region and comptime binding of an analysis
+func Inspect(ctx context.Context, analysis *Analysis, env jsenv.Env, opts Options) (Inspection, error) {
+	program, err := render(analysis, true)
+	if err != nil {
+		return Inspection{}, err
+	}
+	evaluation, err := Evaluate(ctx, program, env, opts)
+	if err != nil {
+		return Inspection{}, err
+	}
+
+	results := program.results
+	inspection := Inspection{
+		Bindings:     map[uint32]map[string]string{},
+		Dependencies: evaluation.Dependencies,
+	}
+	for _, ref := range results.defOrder {
+		switch ref.resultType {
+		case ct_type_region:
+			region := results.regions[ref.index]
+			inspection.Regions = append(inspection.Regions, InspectedValue{
+				Node:  region.Node,
+				Value: region.Result,
+			})
+		case ct_type_jsx_expansion:
+			region := results.regions[results.expansions[ref.index].regionId]
+			inspection.Regions = append(inspection.Regions, InspectedValue{
+				Node:  region.Node,
+				Value: region.Result,
+			})
+		case ct_type_macro:
+			region := results.regions[results.macros[ref.index].regionId]
+			inspection.Regions = append(inspection.Regions, InspectedValue{
+				Node:  region.Node,
+				Value: region.Result,
+			})
+		}
+	}
+	for _, b := range results.bindings {
+		if results.regions[b.regionId].Result == "" {
+			// the value could not be serialized
+			continue
+		}
+		key := b.declaration.StartByte()
+		if inspection.Bindings[key] == nil {
+			inspection.Bindings[key] = map[string]string{}
+		}
+		inspection.Bindings[key][b.name] = results.regions[b.regionId].Result
+	}
+	return inspection, nil
+}
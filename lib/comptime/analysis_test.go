@@ -0,0 +1,174 @@
+package comptime
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// parses, normalizes and analyzes a source
+func analyze(t *testing.T, source string, opts Options) *Analysis {
+	t.Helper()
+	ctx := context.Background()
+	parsed, err := Parse(ctx, []byte(source), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err = Normalize(ctx, parsed, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Analyze(parsed, opts)
+}
+
+// returns the source of every region of a scope and of its children
+func regionTexts(scope *Scope, source []byte) []string {
+	texts := []string{}
+	for _, ref := range scope.DefinitionOrder {
+		switch ref.Type {
+		case DEF_REGION:
+			texts = append(texts, scope.Regions[ref.Index].Content(source))
+		case DEF_SCOPE:
+			texts = append(texts, regionTexts(scope.Scopes[ref.Index], source)...)
+		}
+	}
+	return texts
+}
+
+func TestAnalyzeRegions(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		regions []string
+	}{
+		{
+			name:    "binary expression",
+			source:  "$comptime: const a = 1\nf(a + 1)",
+			regions: []string{"a + 1"},
+		},
+		{
+			name:    "runtime operand",
+			source:  "$comptime: const a = 1\nf(a + b)",
+			regions: []string{"a"},
+		},
+		{
+			name:    "member of a comptime object",
+			source:  "$comptime: const o = { a: 1 }\nf(o.a, o.a.b.c())",
+			regions: []string{"o.a", "o.a.b.c()"},
+		},
+		{
+			name:    "shadowed by a parameter",
+			source:  "$comptime: const a = 1\nfunction f({ a }, b = a) { return a }",
+			regions: []string{},
+		},
+		{
+			name:    "shadowed by a hoisted var",
+			source:  "$comptime: const a = 1\nfunction f() { g(a); var a = 2 }",
+			regions: []string{},
+		},
+		{
+			name:    "shadowed by a catch parameter",
+			source:  "$comptime: const e = 1\ntry { g(e) } catch (e) { g(e) }",
+			regions: []string{"e"},
+		},
+		{
+			name:    "shadowed by a loop binding",
+			source:  "$comptime: const i = 1\nfor (let i = 0; i < 2; i++) g(i)\ng(i)",
+			regions: []string{"i"},
+		},
+		{
+			name:    "declared later",
+			source:  "const f = () => g(v)\n$comptime: const v = 1",
+			regions: []string{"v"},
+		},
+		{
+			name:    "assignment",
+			source:  "$comptime: const a = 1\nlet y\nf((y = 2), a)",
+			regions: []string{"a"},
+		},
+		{
+			name:    "function expression",
+			source:  "$comptime: const n = 1\nexport const f = () => 42, g = () => n + 1",
+			regions: []string{"n + 1"},
+		},
+		{
+			name:    "called function expression",
+			source:  "$comptime: const n = 1\nf((() => n)(), h(() => n))",
+			regions: []string{"(() => n)()", "n"},
+		},
+		{
+			name:    "callback of a comptime call",
+			source:  "$comptime: const o = { a: [1], k: 2 }\nf(o.a.map(x => x * 2), r.map(x => x * o.k))",
+			regions: []string{"o.a.map(x => x * 2)", "o.k"},
+		},
+		{
+			name:    "callback referencing a global",
+			source:  "$comptime: const o = { a: [1] }\nf(o.a.map(x => Math.abs(x)))",
+			regions: []string{"o.a"},
+		},
+		{
+			name:    "jsx handler",
+			source:  "$comptime: const item = { id: 1 }\nconst b = <button onClick={() => item.id} />",
+			regions: []string{"item.id"},
+		},
+		{
+			name:    "define",
+			source:  "if (DEBUG) f()",
+			regions: []string{"DEBUG"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := Options{Defines: map[string]string{"DEBUG": "true"}}
+			analysis := analyze(t, test.source, opts)
+			got := regionTexts(analysis.Root, analysis.Source)
+			if strings.Join(got, "|") != strings.Join(test.regions, "|") {
+				t.Errorf("got regions %q, want %q", got, test.regions)
+			}
+		})
+	}
+}
+
+func TestAnalyzeSelfReference(t *testing.T) {
+	sources := []string{
+		"$comptime: const fact = (n) => n ? n * fact(n - 1) : 1\nf(fact(3))\n",
+		"$comptime: const a = 1, b = a + 1\nf(b)\n",
+		"$comptime: const { c, d = c } = {}\nf(d)\n",
+		"$comptime: class C { static c = new C() }\nf(C.c)\n",
+	}
+	for _, source := range sources {
+		analysis := analyze(t, source, Options{})
+		if HasErrors(analysis.Diagnostics) {
+			t.Errorf("%q: unexpected diagnostics: %v", source, analysis.Diagnostics)
+		}
+	}
+}
+
+func TestAnalyzeLazyReferences(t *testing.T) {
+	sources := []string{
+		"$comptime: const a = () => b\n$comptime: const b = a\n",
+		"$comptime: const handlers = { get: () => registry.get(\"x\") }\n$comptime: const registry = new Map([[\"x\", handlers]])\n",
+		"$comptime: function f() { return g }\n$comptime: const g = { f }\n",
+		// r and q reference each other through functions
+		"$comptime: const p = () => q\n$comptime: const r = p\n$comptime: const s = () => r\n$comptime: const q = s\n",
+	}
+	for _, source := range sources {
+		analysis := analyze(t, source, Options{})
+		if HasErrors(analysis.Diagnostics) {
+			t.Errorf("%q: unexpected diagnostics: %v", source, analysis.Diagnostics)
+		}
+	}
+}
+
+func TestDumpScopesEvaluationOrder(t *testing.T) {
+	analysis := analyze(t, "f(v)\n$comptime: const v = 1", Options{})
+	dump := DumpScopes(analysis, Options{})
+	if dump.Version != JSON_SCOPE_VERSION {
+		t.Errorf("got version %d", dump.Version)
+	}
+	want := []JSONStatementRef{{Type: "declaration", Index: 0}, {Type: "region", Index: 0}}
+	if !slices.Equal(dump.Scope.EvaluationOrder, want) {
+		t.Errorf("got evaluation order %v, want %v", dump.Scope.EvaluationOrder, want)
+	}
+}
@@ -0,0 +1,243 @@
+package comptime
+
+import (
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+/*
+the runtime bindings of a scope are collected when the scope is created,
+before any of its code is analyzed, since a binding can be referenced before
+it is declared:
+- `var` declarations and function declarations are hoisted to the function
+  (or the file) containing them
+- `let`, `const` and class declarations shadow outer bindings in their whole
+  block, even before they are declared
+*/
+
+// returns true if the node creates a scope
+func createsScope(nodeType string) bool {
+	switch nodeType {
+	case "statement_block",
+		"generator_function",
+		"generator_function_declaration",
+		"function",
+		"function_expression",
+		"function_declaration",
+		"arrow_function",
+		"method_definition",
+		"class",
+		"catch_clause",
+		"for_statement",
+		"for_in_statement",
+		"switch_body":
+		return true
+	}
+	return false
+}
+
+// returns true if the labeled statement is comptime code, which declares
+// no runtime binding
+func isComptimeLabel(node *sitter.Node, source []byte) bool {
+	if node.Type() != "labeled_statement" {
+		return false
+	}
+	label := node.ChildByFieldName("label").Content(source)
+	return label == COMPTIME_KEYWORD || label == MACRO_KEYWORD
+}
+
+// returns the runtime bindings declared in the scope created by the node
+func scopeBindings(node *sitter.Node, source []byte) []string {
+	switch node.Type() {
+	case "program":
+		return append(varBindings(node, source), lexicalBindings(node, source)...)
+	case "statement_block":
+		if parent := node.Parent(); parent != nil && isFunction(parent.Type()) {
+			// declared in the scope of the function
+			return nil
+		}
+		return lexicalBindings(node, source)
+	case "switch_body":
+		ids := []string{}
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			ids = append(ids, lexicalBindings(node.NamedChild(i), source)...)
+		}
+		return ids
+	case "catch_clause":
+		return getDeclaredVars(node.ChildByFieldName("parameter"), source)
+	case "for_statement":
+		initializer := node.ChildByFieldName("initializer")
+		if initializer != nil && initializer.Type() == "lexical_declaration" {
+			return parseLexicalDecl(initializer, source)
+		}
+		return nil
+	case "for_in_statement":
+		switch forKind(node) {
+		case "let", "const":
+			return getDeclaredVars(node.ChildByFieldName("left"), source)
+		}
+		return nil
+	case "class":
+		// the name of a class expression is only visible in its body
+		if name := node.ChildByFieldName("name"); name != nil {
+			return []string{name.Content(source)}
+		}
+		return nil
+	}
+
+	if !isFunction(node.Type()) {
+		return nil
+	}
+	ids := getParameterIdentifiers(node, source)
+	// the name of a function expression is only visible in its body
+	if node.Type() != "function_declaration" && node.Type() != "generator_function_declaration" {
+		if name := node.ChildByFieldName("name"); name != nil {
+			ids = append(ids, name.Content(source))
+		}
+	}
+	body := node.ChildByFieldName("body")
+	if body != nil && body.Type() == "statement_block" {
+		ids = append(ids, varBindings(body, source)...)
+		ids = append(ids, lexicalBindings(body, source)...)
+	}
+	return ids
+}
+
+// returns the identifiers of the declarations with the given label (comptime
+// or macro) directly in the scope created by the node
+func labelBindings(node *sitter.Node, source []byte, label string) []string {
+	statements := []*sitter.Node{}
+	switch node.Type() {
+	case "program", "statement_block":
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			statements = append(statements, node.NamedChild(i))
+		}
+	case "switch_body":
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			switchCase := node.NamedChild(i)
+			for j := 0; j < int(switchCase.NamedChildCount()); j++ {
+				statements = append(statements, switchCase.NamedChild(j))
+			}
+		}
+	}
+
+	ids := []string{}
+	for _, statement := range statements {
+		if statement.Type() != "labeled_statement" ||
+			statement.ChildByFieldName("label").Content(source) != label {
+			continue
+		}
+		ids = append(ids, definedIdentifiers(statement.ChildByFieldName("body"), source)...)
+	}
+	return ids
+}
+
+// returns the keyword declaring the variable of a `for in` or `for of` loop,
+// an empty string is returned if the variable is not declared by the loop
+func forKind(node *sitter.Node) string {
+	for i := 0; i < int(node.ChildCount()); i++ {
+		child := node.Child(i)
+		if child.IsNamed() {
+			continue
+		}
+		switch child.Type() {
+		case "var", "let", "const":
+			return child.Type()
+		}
+	}
+	return ""
+}
+
+// returns the bindings of the `var` declarations hoisted to the scope of a
+// function or of the file
+func varBindings(node *sitter.Node, source []byte) []string {
+	ids := []string{}
+	for i := 0; i < int(node.NamedChildCount()); i++ {
+		ids = append(ids, declaredVars(node.NamedChild(i), source)...)
+	}
+	return ids
+}
+
+// returns the bindings of the `var` declarations of a statement, the ones of
+// the functions it contains are excluded
+func declaredVars(node *sitter.Node, source []byte) []string {
+	ids := []string{}
+	var search func(node *sitter.Node)
+	search = func(node *sitter.Node) {
+		switch {
+		case isFunction(node.Type()), node.Type() == "class_body",
+			isComptimeLabel(node, source):
+			return
+		case node.Type() == "variable_declaration":
+			ids = append(ids, parseLexicalDecl(node, source)...)
+		case node.Type() == "for_in_statement" && forKind(node) == "var":
+			ids = append(ids, getDeclaredVars(node.ChildByFieldName("left"), source)...)
+		}
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			search(node.NamedChild(i))
+		}
+	}
+	search(node)
+	return ids
+}
+
+// returns the bindings of the declarations directly in a block, `var`
+// declarations are excluded as they are hoisted
+func lexicalBindings(block *sitter.Node, source []byte) []string {
+	ids := []string{}
+	for i := 0; i < int(block.NamedChildCount()); i++ {
+		statement := block.NamedChild(i)
+		if statement.Type() == "export_statement" {
+			statement = statement.ChildByFieldName("declaration")
+			if statement == nil {
+				continue
+			}
+		}
+		switch statement.Type() {
+		case "lexical_declaration",
+			"class_declaration",
+			"abstract_class_declaration",
+			"function_declaration",
+			"generator_function_declaration":
+			ids = append(ids, definedIdentifiers(statement, source)...)
+		case "enum_declaration":
+			ids = append(ids, statement.ChildByFieldName("name").Content(source))
+		case "import_statement":
+			ids = append(ids, importBindings(statement, source)...)
+		}
+	}
+	return ids
+}
+
+// returns the bindings of an import statement
+func importBindings(statement *sitter.Node, source []byte) []string {
+	ids := []string{}
+	for i := 0; i < int(statement.NamedChildCount()); i++ {
+		clause := statement.NamedChild(i)
+		if clause.Type() != "import_clause" {
+			continue
+		}
+		for j := 0; j < int(clause.NamedChildCount()); j++ {
+			child := clause.NamedChild(j)
+			switch child.Type() {
+			case "identifier":
+				// the default import
+				ids = append(ids, child.Content(source))
+			case "namespace_import":
+				ids = append(ids, child.NamedChild(0).Content(source))
+			case "named_imports":
+				for k := 0; k < int(child.NamedChildCount()); k++ {
+					specifier := child.NamedChild(k)
+					if specifier.Type() != "import_specifier" {
+						continue
+					}
+					name := specifier.ChildByFieldName("alias")
+					if name == nil {
+						name = specifier.ChildByFieldName("name")
+					}
+					ids = append(ids, name.Content(source))
+				}
+			}
+		}
+	}
+	return ids
+}
@@ -1,18 +1,119 @@
 package comptime
 
 import (
-	"bytes"
-	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"jscomptime/lib/jsenv"
-	"os"
+	"slices"
 
 	sitter "github.com/smacker/go-tree-sitter"
-	"github.com/smacker/go-tree-sitter/javascript"
 )
 
+// returns true if a comptime expression of the given type can be replaced
+// with its evaluated value
+func inlineable(nodeType string) bool {
+	switch nodeType {
+	case "identifier",
+		"generator_function",
+		"function",
+		"arrow_function",
+		"await_expression",
+		"binary_expression",
+		"new_expression",
+		"ternary_expression",
+		"array",
+		"call_expression",
+		"member_expression",
+		"object",
+		"parenthesized_expression",
+		"subscript_expression",
+		"template_string",
+		"unary_expression":
+		return true
+	}
+	return false
+}
+
+// returns true if the statement requires its condition to be parenthesized
+func conditionParent(nodeType string) bool {
+	switch nodeType {
+	case "if_statement",
+		"while_statement",
+		"do_statement",
+		"switch_statement",
+		"with_statement":
+		return true
+	}
+	return false
+}
+
+// adds the regions of a comptime expression, the arguments of a call are
+// inlined individually
+func addComptimeRegions(node *sitter.Node, scope *Scope) {
+	switch {
+	case node.Type() == "parenthesized_expression" && node.Parent() != nil &&
+		conditionParent(node.Parent().Type()):
+		// the parentheses of `if (...)` are part of the statement
+		addComptimeRegions(jsxExpressionInner(node), scope)
+	case isFunction(node.Type()):
+		// a function passed to a runtime call keeps the regions of its body
+		scope.addClosure(node)
+	case inlineable(node.Type()):
+		scope.addRegion(node)
+	case typeAssertion(node.Type()):
+		// the value is inlined, the assertion is kept
+		addComptimeRegions(node.NamedChild(0), scope)
+	case node.Type() == "arguments", node.Type() == "spread_element",
+		node.Type() == "sequence_expression":
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			addComptimeRegions(node.NamedChild(i), scope)
+		}
+	case node.Type() == "pair":
+		key := node.ChildByFieldName("key")
+		if key.Type() == "computed_property_name" {
+			addComptimeRegions(key.NamedChild(0), scope)
+		}
+		addComptimeRegions(node.ChildByFieldName("value"), scope)
+	}
+}
+
+// the target of an assignment, an update or a delete is never inlined, only
+// the index of a subscript and the runtime object of a property can contain
+// regions
+func handleTarget(node *sitter.Node, scope *Scope, childScope *Scope, source []byte) {
+	switch node.Type() {
+	case "subscript_expression":
+		index := node.ChildByFieldName("index")
+		if recurse(index, childScope, source) == type_comptime {
+			addComptimeRegions(index, scope)
+		}
+		recurse(node.ChildByFieldName("object"), childScope, source)
+	case "member_expression":
+		recurse(node.ChildByFieldName("object"), childScope, source)
+	}
+}
+
+// returns true if a function only references its own bindings and comptime
+// bindings, such a function can be evaluated with the call it is passed to
+func closedFunction(node *sitter.Node, scope *Scope, shadowed []string, source []byte) bool {
+	switch node.Type() {
+	case "this", "super":
+		return false
+	case "identifier", "shorthand_property_identifier":
+		id := node.Content(source)
+		return slices.Contains(shadowed, id) || resolve(id, scope)
+	}
+	if createsScope(node.Type()) {
+		shadowed = append(slices.Clip(shadowed), scopeBindings(node, source)...)
+	}
+	for i := 0; i < int(node.NamedChildCount()); i++ {
+		if !closedFunction(node.NamedChild(i), scope, shadowed, source) {
+			return false
+		}
+	}
+	return true
+}
+
 func handleComptimeBody(node *sitter.Node, scope *Scope, source []byte) {
 	ids := definedIdentifiers(node, source)
 	if len(ids) > 0 {
@@ -38,114 +139,166 @@ func recurse(node *sitter.Node, scope *Scope, source []byte) childType {
 			// don't handle comptime body children
 			return type_comptime
 		}
+		if label == MACRO_KEYWORD {
+			handleMacroBody(node.ChildByFieldName("body"), scope, source)
+			return type_comptime
+		}
 	}
 
-	// handle runtime var declarations
-	ids := definedIdentifiers(node, source)
-	if len(ids) > 0 {
-		scope.RuntimeDeclarations = append(scope.RuntimeDeclarations, ids...)
-	} else {
-		// handle scope
-		switch nodeType {
-		case "statement_block":
-			childScope = &Scope{Parent: scope}
-		case "generator_function",
-			"generator_function_declaration",
-			"function",
-			"function_declaration",
-			"arrow_function",
-			"method_definition":
-			childScope = &Scope{
-				Parent:              scope,
-				RuntimeDeclarations: getParameterIdentifiers(node, source),
-			}
+	if nodeType == "call_expression" && isMacroCall(node, scope, source) {
+		scope.addMacro(Macro{
+			Node:      node,
+			Arguments: macroArguments(node),
+		})
+		return type_runtime
+	}
+
+	// handle scope, runtime declarations are collected with the scope
+	// declaring them
+	if createsScope(nodeType) {
+		childScope = &Scope{
+			Parent:              scope,
+			Node:                node,
+			RuntimeDeclarations: scopeBindings(node, source),
+			ComptimeIdentifiers: labelBindings(node, source, COMPTIME_KEYWORD),
+			MacroIdentifiers:    labelBindings(node, source, MACRO_KEYWORD),
 		}
+	}
 
-		// handle regions (expressions that can be replaced with an evaluated comptime value)
-		switch nodeType {
-		case "expression_statement":
-			return recurse(node.NamedChild(0), childScope, source)
-		case "generator_function",
-			"function",
-			"arrow_function":
-			return recurse(node.ChildByFieldName("body"), childScope, source)
-		case "await_expression",
-			"binary_expression",
-			"new_expression",
-			"ternary_expression",
-			"array",
-			"call_expression",
-			"member_expression",
-			"object",
-			"parenthesized_expression",
-			"subscript_expression",
-			"template_string":
-			comptimeExprs := []*sitter.Node{}
-			hasRuntime := false
-
-			// is comptime-able expression
-			for i := 0; i < int(node.NamedChildCount()); i++ {
-				child := node.NamedChild(i)
-				childType := recurse(child, childScope, source)
-				switch childType {
-				case type_runtime:
-					hasRuntime = true
-				case type_comptime:
-					comptimeExprs = append(comptimeExprs, child)
-				}
-			}
+	if nodeType == "call_expression" &&
+		node.ChildByFieldName("arguments").Type() == "template_string" {
+		return recurseTaggedTemplate(node, scope, childScope, source)
+	}
 
-			if !hasRuntime {
-				return type_comptime
-			}
-			for _, e := range comptimeExprs {
-				scope.addRegion(e)
+	// handle regions (expressions that can be replaced with an evaluated comptime value)
+	switch nodeType {
+	case "expression_statement":
+		expr := node.NamedChild(0)
+		if recurse(expr, childScope, source) == type_comptime {
+			addComptimeRegions(expr, scope)
+		}
+		return type_invalid
+	case "generator_function",
+		"function",
+		"arrow_function":
+		body := node.ChildByFieldName("body")
+		bodyType := recurse(body, childScope, source)
+		if bodyType == type_comptime {
+			addComptimeRegions(body, childScope)
+		}
+		// a function is a runtime value, it is only folded with the call it
+		// is called by or passed to, its scope is added with the regions of
+		// that call if the call is not folded
+		if calledFunction(node) &&
+			(bodyType == type_comptime || closedFunction(node, scope, nil, source)) {
+			scope.setClosure(node, childScope)
+			return type_comptime
+		}
+		if len(childScope.DefinitionOrder) > 0 ||
+			len(childScope.RuntimeDeclarations) > 0 {
+			scope.addScope(childScope)
+		}
+		return type_runtime
+	case "await_expression",
+		"arguments",
+		"binary_expression",
+		"new_expression",
+		"ternary_expression",
+		"array",
+		"call_expression",
+		"member_expression",
+		"object",
+		"pair",
+		"computed_property_name",
+		"spread_element",
+		"parenthesized_expression",
+		"sequence_expression",
+		"subscript_expression":
+		comptimeExprs := []*sitter.Node{}
+		hasRuntime := false
+
+		// is comptime-able expression
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			child := node.NamedChild(i)
+			childType := recurse(child, childScope, source)
+			switch childType {
+			case type_runtime:
+				hasRuntime = true
+			case type_comptime:
+				comptimeExprs = append(comptimeExprs, child)
 			}
-			return type_runtime
-		case "true",
-			"false",
-			"null",
-			"number",
-			"string",
-			"regex",
-			"undefined":
+		}
+
+		if !hasRuntime {
 			return type_comptime
-		case "identifier":
-			id := node.Content(source)
-			resolved := resolve(id, scope)
-			if resolved {
-				return type_comptime
+		}
+		callee := node.ChildByFieldName("function")
+		for _, e := range comptimeExprs {
+			// inline the receiver of a method call instead of the method
+			// so that `this` is kept
+			if nodeType == "call_expression" && callee != nil &&
+				e.Equal(callee) && callee.Type() == "member_expression" {
+				e = callee.ChildByFieldName("object")
 			}
+			addComptimeRegions(e, scope)
+		}
+		return type_runtime
+	case "as_expression", "satisfies_expression", "non_null_expression":
+		// x as T, x satisfies T and x! have the type of x
+		return recurse(node.NamedChild(0), childScope, source)
+	case "template_string":
+		return recurseTemplate(node, scope, childScope, source)
+	case "jsx_element",
+		"jsx_self_closing_element":
+		recurseJSX(node, childScope, source)
+		return type_runtime
+	case "assignment_expression", "augmented_assignment_expression":
+		handleTarget(node.ChildByFieldName("left"), scope, childScope, source)
+		right := node.ChildByFieldName("right")
+		if recurse(right, childScope, source) == type_comptime {
+			addComptimeRegions(right, scope)
+		}
+		// assignments have effects at runtime
+		return type_runtime
+	case "update_expression":
+		handleTarget(node.ChildByFieldName("argument"), scope, childScope, source)
+		return type_runtime
+	case "unary_expression":
+		argument := node.ChildByFieldName("argument")
+		if node.ChildByFieldName("operator").Type() == "delete" {
+			handleTarget(argument, scope, childScope, source)
 			return type_runtime
 		}
+		return recurse(argument, childScope, source)
+	case "true",
+		"false",
+		"null",
+		"number",
+		"string",
+		"regex",
+		"undefined":
+		return type_comptime
+	case "identifier", "shorthand_property_identifier":
+		id := node.Content(source)
+		resolved := resolve(id, scope)
+		if resolved {
+			return type_comptime
+		}
+		return type_runtime
 	}
 
 	for i := 0; i < int(node.NamedChildCount()); i++ {
 		child := node.NamedChild(i)
 		childType := recurse(child, childScope, source)
 		if childType == type_comptime {
-			switch child.Type() {
-			case "identifier",
-				"generator_function",
-				"function",
-				"arrow_function",
-				"await_expression",
-				"binary_expression",
-				"new_expression",
-				"ternary_expression",
-				"array",
-				"call_expression",
-				"member_expression",
-				"object",
-				"parenthesized_expression",
-				"subscript_expression",
-				"template_string":
-				scope.addRegion(child)
-			}
+			addComptimeRegions(child, scope)
 		}
 	}
 
-	if childScope != scope && len(childScope.DefinitionOrder) > 0 {
+	// scopes with runtime declarations are kept as they shadow comptime
+	// declarations
+	if childScope != scope && (len(childScope.DefinitionOrder) > 0 ||
+		len(childScope.RuntimeDeclarations) > 0) {
 		scope.addScope(childScope)
 	}
 
@@ -157,6 +310,8 @@ type comptimeResultType = uint8
 const (
 	ct_type_region comptimeResultType = iota
 	ct_type_statement
+	ct_type_jsx_expansion
+	ct_type_macro
 )
 
 type nodeRef struct {
@@ -164,10 +319,48 @@ type nodeRef struct {
 	index      int
 }
 
+type jsxExpansionResult struct {
+	expansion JSXExpansion
+	regionId  int
+}
+
+type macroResult struct {
+	macro    Macro
+	regionId int
+}
+
+type bindingResult struct {
+	declaration *sitter.Node
+	name        string
+	regionId    int
+}
+
 type comptimeResults struct {
 	defOrder   []nodeRef
 	statements []*sitter.Node
 	regions    []jsenv.EvalResult
+	expansions []jsxExpansionResult
+	macros     []macroResult
+	// exports the value of the identifiers of every comptime declaration
+	exportBindings bool
+	bindings       []bindingResult
+	// the modules imported by the values, set once they are evaluated
+	imports []jsenv.Import
+}
+
+// returns the node replaced or removed by a result
+func (r *comptimeResults) node(ref nodeRef) *sitter.Node {
+	switch ref.resultType {
+	case ct_type_region:
+		return r.regions[ref.index].Node
+	case ct_type_statement:
+		return r.statements[ref.index]
+	case ct_type_jsx_expansion:
+		return r.regions[r.expansions[ref.index].regionId].Node
+	case ct_type_macro:
+		return r.regions[r.macros[ref.index].regionId].Node
+	}
+	return nil
 }
 
 func renderComptimeCode(
@@ -177,7 +370,16 @@ func renderComptimeCode(
 	out io.Writer,
 ) error {
 	var err error
-	for _, ref := range scope.DefinitionOrder {
+	for _, ref := range scope.evaluationOrder() {
+		if ref.Type != DEF_SCOPE {
+			// the position reflected to comptime code
+			start := scope.definitionNode(ref).StartPoint()
+			_, err = fmt.Fprintf(out, "__jscomptime_locate(%d, %d)\n", start.Row+1, start.Column+1)
+			if err != nil {
+				return err
+			}
+		}
+
 		switch ref.Type {
 		case DEF_SCOPE:
 			_, err = out.Write([]byte("{\n"))
@@ -201,12 +403,13 @@ func renderComptimeCode(
 				index:      len(results.statements) - 1,
 			})
 
-			_, err = out.Write([]byte(node.Content(source)))
+			_, err = out.Write([]byte(stripTypes(node, source)))
 			if err != nil {
 				return err
 			}
 		case DEF_COMPTIME_DECLARATION:
-			node := scope.ComptimeDeclarations[ref.Index].Node
+			decl := scope.ComptimeDeclarations[ref.Index]
+			node := decl.Node
 
 			results.statements = append(results.statements, node.Parent())
 			results.defOrder = append(results.defOrder, nodeRef{
@@ -214,10 +417,31 @@ func renderComptimeCode(
 				index:      len(results.statements) - 1,
 			})
 
-			_, err = out.Write([]byte(node.Content(source)))
+			_, err = out.Write([]byte(stripTypes(node, source)))
 			if err != nil {
 				return err
 			}
+
+			if !results.exportBindings {
+				break
+			}
+			for _, id := range decl.Identifiers {
+				results.regions = append(results.regions, jsenv.EvalResult{
+					Node: node,
+				})
+				results.bindings = append(results.bindings, bindingResult{
+					declaration: node,
+					name:        id,
+					regionId:    len(results.regions) - 1,
+				})
+				_, err = out.Write([]byte(fmt.Sprintf(
+					"\nawait __jscomptime_export_binding(%d, %s)",
+					len(results.regions)-1, id,
+				)))
+				if err != nil {
+					return err
+				}
+			}
 		case DEF_REGION:
 			regionNode := scope.Regions[ref.Index]
 
@@ -230,14 +454,64 @@ func renderComptimeCode(
 				index:      regionId,
 			})
 
+			expr := stripTypes(regionNode, source)
+			if regionNode.Type() == "template_substitution" {
+				// converted to a string the way the template would
+				expr = "`" + expr + "`"
+			}
 			export := fmt.Sprintf(
-				"__jscomptime_export_value(%d, %s)",
-				regionId, regionNode.Content(source),
+				"await __jscomptime_export_value(%d, %s)",
+				regionId, expr,
 			)
+
+			_, err = out.Write([]byte(export))
 			if err != nil {
 				return err
 			}
+		case DEF_JSX_EXPANSION:
+			expansion := scope.JSXExpansions[ref.Index]
+
+			results.regions = append(results.regions, jsenv.EvalResult{
+				Node: expansion.Node,
+			})
+			results.expansions = append(results.expansions, jsxExpansionResult{
+				expansion: expansion,
+				regionId:  len(results.regions) - 1,
+			})
+			results.defOrder = append(results.defOrder, nodeRef{
+				resultType: ct_type_jsx_expansion,
+				index:      len(results.expansions) - 1,
+			})
+
+			export := fmt.Sprintf(
+				"await __jscomptime_export_value(%d, %s, true)",
+				len(results.regions)-1,
+				renderJSXExpansionExport(expansion, source),
+			)
+			_, err = out.Write([]byte(export))
+			if err != nil {
+				return err
+			}
+		case DEF_MACRO:
+			macro := scope.Macros[ref.Index]
+
+			results.regions = append(results.regions, jsenv.EvalResult{
+				Node: macro.Node,
+			})
+			results.macros = append(results.macros, macroResult{
+				macro:    macro,
+				regionId: len(results.regions) - 1,
+			})
+			results.defOrder = append(results.defOrder, nodeRef{
+				resultType: ct_type_macro,
+				index:      len(results.macros) - 1,
+			})
 
+			export := fmt.Sprintf(
+				"await __jscomptime_export_value(%d, %s, true)",
+				len(results.regions)-1,
+				renderMacroCall(macro, source),
+			)
 			_, err = out.Write([]byte(export))
 			if err != nil {
 				return err
@@ -250,74 +524,3 @@ func renderComptimeCode(
 	}
 	return nil
 }
-
-func Compile(ctx context.Context, source []byte, env jsenv.Env) (string, error) {
-	parser := sitter.NewParser()
-	parser.SetLanguage(javascript.GetLanguage())
-	tree, err := parser.ParseCtx(context.Background(), nil, source)
-	if err != nil {
-		return "", err
-	}
-
-	root := &Scope{}
-	recurse(tree.RootNode(), root, source)
-
-	// Debug info
-	jsonScope := TransformToJSONScope(root, source)
-	serialized, err := json.Marshal(jsonScope)
-	if err != nil {
-		return "", err
-	}
-	err = os.WriteFile("debug.json", serialized, 0777)
-	if err != nil {
-		return "", err
-	}
-
-	code := bytes.NewBuffer(nil)
-	results := comptimeResults{}
-	err = renderComptimeCode(root, source, &results, code)
-	if err != nil {
-		return "", err
-	}
-
-	err = env.Eval(ctx, code.String(), results.regions)
-	if err != nil {
-		return "", err
-	}
-
-	output := bytes.NewBuffer(nil)
-	cursor := 0
-
-	for _, res := range results.defOrder {
-		switch res.resultType {
-		case ct_type_region:
-			region := results.regions[res.index]
-
-			fmt.Printf("%s\t%s\n", region.Node.Content(source), region.Result)
-			fmt.Println(cursor, region.Node.StartByte())
-
-			_, err := output.Write(source[cursor:region.Node.StartByte()])
-			if err != nil {
-				return "", err
-			}
-			_, err = output.Write([]byte(region.Result))
-			if err != nil {
-				return "", err
-			}
-			cursor = int(region.Node.EndByte())
-		case ct_type_statement:
-			statement := results.statements[res.index]
-			_, err := output.Write(source[cursor:statement.StartByte()])
-			if err != nil {
-				return "", err
-			}
-			cursor = int(statement.EndByte())
-		}
-	}
-	_, err = output.Write(source[cursor:])
-	if err != nil {
-		return "", err
-	}
-
-	return output.String(), nil
-}
@@ -0,0 +1,467 @@
+package comptime
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+/*
+inlined values often leave behind conditions that are known at compile time,
+the dead code elimination removes the code they make unreachable:
+- `if` statements with a constant condition are replaced by the branch taken
+- ternaries with a constant condition are replaced by the branch taken
+- `&&`, `||` and `??` with a constant left operand are replaced by the operand
+  they evaluate to
+- expression statements left with a constant value are removed
+
+`var` declarations are hoisted out of the branches declaring them, they are
+kept without their values when a whole `if` statement is removed, other
+branches declaring them are left as they are, as are branches declaring
+functions (which are hoisted as well in sloppy mode)
+
+only literals are considered constant so that nothing with side effects is
+ever removed from the path that is taken
+*/
+
+type constantKind = uint8
+
+const (
+	const_string constantKind = iota
+	const_number
+	const_boolean
+	const_null
+	const_undefined
+)
+
+// the value of a constant expression
+type constant struct {
+	kind    constantKind
+	str     string
+	number  float64
+	boolean bool
+}
+
+func (c constant) truthy() bool {
+	switch c.kind {
+	case const_string:
+		return c.str != ""
+	case const_number:
+		// NaN is not equal to itself
+		return c.number != 0 && c.number == c.number
+	case const_boolean:
+		return c.boolean
+	}
+	return false
+}
+
+func (c constant) nullish() bool {
+	return c.kind == const_null || c.kind == const_undefined
+}
+
+// returns the value of a number literal
+func numberValue(literal string) (float64, bool) {
+	literal = strings.ReplaceAll(literal, "_", "")
+	if strings.HasSuffix(literal, "n") {
+		// bigints are not numbers
+		return 0, false
+	}
+	if len(literal) > 1 && literal[0] == '0' {
+		switch literal[1] {
+		case 'x', 'X', 'o', 'O', 'b', 'B':
+			n, err := strconv.ParseUint(literal, 0, 64)
+			return float64(n), err == nil
+		case '.', 'e', 'E':
+		default:
+			// legacy octal literals
+			return 0, false
+		}
+	}
+	n, err := strconv.ParseFloat(literal, 64)
+	return n, err == nil
+}
+
+// returns the value of a string literal
+func stringValue(node *sitter.Node, source []byte) (string, bool) {
+	literal := node.Content(source)
+	if len(literal) < 2 {
+		return "", false
+	}
+	if !strings.Contains(literal, "\\") {
+		return literal[1 : len(literal)-1], true
+	}
+	if literal[0] != '"' {
+		return "", false
+	}
+	// the escapes shared by javascript and go
+	value, err := strconv.Unquote(literal)
+	return value, err == nil
+}
+
+// returns the value of an expression made only of literals
+func constantValue(node *sitter.Node, source []byte) (constant, bool) {
+	switch node.Type() {
+	case "string":
+		value, ok := stringValue(node, source)
+		return constant{kind: const_string, str: value}, ok
+	case "template_string":
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			if node.NamedChild(i).Type() == "template_substitution" {
+				return constant{}, false
+			}
+		}
+		literal := node.Content(source)
+		if strings.Contains(literal, "\\") {
+			return constant{}, false
+		}
+		return constant{kind: const_string, str: literal[1 : len(literal)-1]}, true
+	case "number":
+		value, ok := numberValue(node.Content(source))
+		return constant{kind: const_number, number: value}, ok
+	case "true", "false":
+		return constant{kind: const_boolean, boolean: node.Type() == "true"}, true
+	case "null":
+		return constant{kind: const_null}, true
+	case "undefined":
+		return constant{kind: const_undefined}, true
+	case "parenthesized_expression":
+		inner := jsxExpressionInner(node)
+		if inner == nil {
+			return constant{}, false
+		}
+		return constantValue(inner, source)
+	case "unary_expression":
+		argument, ok := constantValue(node.ChildByFieldName("argument"), source)
+		if !ok {
+			return constant{}, false
+		}
+		switch node.ChildByFieldName("operator").Type() {
+		case "!":
+			return constant{kind: const_boolean, boolean: !argument.truthy()}, true
+		case "void":
+			return constant{kind: const_undefined}, true
+		case "-":
+			if argument.kind == const_number {
+				return constant{kind: const_number, number: -argument.number}, true
+			}
+		}
+	case "binary_expression":
+		left, ok := constantValue(node.ChildByFieldName("left"), source)
+		if !ok {
+			return constant{}, false
+		}
+		operator := node.ChildByFieldName("operator").Type()
+		switch operator {
+		case "&&", "||", "??":
+			if takesLeft(operator, left) {
+				return left, true
+			}
+			return constantValue(node.ChildByFieldName("right"), source)
+		}
+		right, ok := constantValue(node.ChildByFieldName("right"), source)
+		if !ok {
+			return constant{}, false
+		}
+		switch operator {
+		case "===", "!==", "==", "!=":
+			equal, ok := constantsEqual(left, right, len(operator) == 3)
+			if !ok {
+				return constant{}, false
+			}
+			if operator[0] == '!' {
+				equal = !equal
+			}
+			return constant{kind: const_boolean, boolean: equal}, true
+		}
+	}
+	return constant{}, false
+}
+
+// returns true if a logical operator evaluates to its left operand
+func takesLeft(operator string, left constant) bool {
+	switch operator {
+	case "&&":
+		return !left.truthy()
+	case "||":
+		return left.truthy()
+	}
+	return !left.nullish()
+}
+
+// compares constants, loose comparisons of values of different kinds (other
+// than null and undefined) are not folded
+func constantsEqual(left, right constant, strict bool) (bool, bool) {
+	if left.kind != right.kind {
+		if strict {
+			return false, true
+		}
+		return left.nullish() && right.nullish(), left.nullish() == right.nullish()
+	}
+	switch left.kind {
+	case const_string:
+		return left.str == right.str, true
+	case const_number:
+		return left.number == right.number, true
+	case const_boolean:
+		return left.boolean == right.boolean, true
+	}
+	return true, true
+}
+
+// returns the expression an expression evaluates to once its constant
+// conditions are folded, this is the expression itself if nothing is folded
+func foldExpression(node *sitter.Node, source []byte) *sitter.Node {
+	switch node.Type() {
+	case "ternary_expression":
+		condition, ok := constantValue(node.ChildByFieldName("condition"), source)
+		if !ok {
+			return node
+		}
+		if condition.truthy() {
+			return foldExpression(node.ChildByFieldName("consequence"), source)
+		}
+		return foldExpression(node.ChildByFieldName("alternative"), source)
+	case "binary_expression":
+		operator := node.ChildByFieldName("operator").Type()
+		switch operator {
+		case "&&", "||", "??":
+		default:
+			return node
+		}
+		left, ok := constantValue(node.ChildByFieldName("left"), source)
+		if !ok {
+			return node
+		}
+		if takesLeft(operator, left) {
+			return foldExpression(node.ChildByFieldName("left"), source)
+		}
+		return foldExpression(node.ChildByFieldName("right"), source)
+	}
+	return node
+}
+
+// returns true if a statement is an `if` statement none of whose branches is
+// ever taken
+func isDeadBranch(statement *sitter.Node, source []byte) bool {
+	if statement == nil || statement.Type() != "if_statement" {
+		return false
+	}
+	condition, ok := constantValue(statement.ChildByFieldName("condition"), source)
+	if !ok || condition.truthy() {
+		return false
+	}
+	alternative := statement.ChildByFieldName("alternative")
+	return alternative == nil || isDeadBranch(jsxExpressionInner(alternative), source)
+}
+
+// returns the `var` bindings declared in dead code, which are hoisted out of
+// it, ok is false if it declares functions in blocks, which are hoisted as
+// well in sloppy mode
+func hoistedBindings(node *sitter.Node, source []byte) ([]string, bool) {
+	var declaresFunction func(node *sitter.Node) bool
+	declaresFunction = func(node *sitter.Node) bool {
+		switch {
+		case node.Type() == "function_declaration",
+			node.Type() == "generator_function_declaration":
+			return true
+		case isFunction(node.Type()), node.Type() == "class_body":
+			return false
+		}
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			if declaresFunction(node.NamedChild(i)) {
+				return true
+			}
+		}
+		return false
+	}
+	return declaredVars(node, source), !declaresFunction(node)
+}
+
+type dceCtx struct {
+	source []byte
+	output *rewriter
+	cursor int
+}
+
+// copies the source up to start and skips it up to end
+func (c *dceCtx) skip(start, end int) {
+	c.output.keep(c.cursor, start)
+	c.cursor = end
+}
+
+// removes a statement along with the line it leaves empty
+func (c *dceCtx) removeStatement(statement *sitter.Node) {
+	start, end := int(statement.StartByte()), int(statement.EndByte())
+	switch statement.Parent().Type() {
+	case "if_statement", "else_clause", "for_statement", "for_in_statement",
+		"while_statement", "do_statement", "with_statement", "labeled_statement":
+		// the parent requires a statement
+		c.skip(start, end)
+		c.output.insert("{}", start)
+		return
+	}
+
+	lineStart := start
+	for lineStart > 0 && (c.source[lineStart-1] == ' ' || c.source[lineStart-1] == '\t') {
+		lineStart--
+	}
+	lineEnd := end
+	for lineEnd < len(c.source) && (c.source[lineEnd] == ' ' || c.source[lineEnd] == '\t') {
+		lineEnd++
+	}
+	if (lineStart == 0 || c.source[lineStart-1] == '\n') &&
+		(lineEnd == len(c.source) || c.source[lineEnd] == '\n') {
+		start = lineStart
+		end = min(lineEnd+1, len(c.source))
+	}
+	c.skip(start, end)
+}
+
+// replaces a node by one of its descendants
+func (c *dceCtx) replace(node *sitter.Node, by *sitter.Node, parenthesize bool) {
+	c.skip(int(node.StartByte()), int(by.StartByte()))
+	if parenthesize {
+		c.output.insert("(", int(by.StartByte()))
+	}
+	c.walk(by)
+	c.output.keep(c.cursor, int(by.EndByte()))
+	if parenthesize {
+		c.output.insert(")", int(by.EndByte()))
+	}
+	c.cursor = int(node.EndByte())
+}
+
+// returns true if an expression replacing a folded expression must be
+// wrapped in parentheses
+func needsParentheses(folded *sitter.Node, by *sitter.Node) bool {
+	parent := folded.Parent()
+	switch by.Type() {
+	case "assignment_expression", "augmented_assignment_expression",
+		"arrow_function", "yield_expression":
+		switch parent.Type() {
+		case "parenthesized_expression", "expression_statement":
+			return false
+		}
+		return true
+	case "object", "function_expression", "function", "class":
+		// would be parsed as a declaration or a block
+		return parent.Type() == "expression_statement"
+	}
+	return false
+}
+
+func (c *dceCtx) walk(node *sitter.Node) {
+	switch node.Type() {
+	case "if_statement":
+		condition, ok := constantValue(node.ChildByFieldName("condition"), c.source)
+		if !ok {
+			break
+		}
+		var branch *sitter.Node
+		if condition.truthy() {
+			branch = node.ChildByFieldName("consequence")
+		} else if alternative := node.ChildByFieldName("alternative"); alternative != nil {
+			branch = jsxExpressionInner(alternative)
+			if isDeadBranch(branch, c.source) {
+				branch = nil
+			}
+		}
+		if branch == nil {
+			ids, ok := hoistedBindings(node, c.source)
+			if !ok {
+				break
+			}
+			if len(ids) > 0 {
+				// the declarations outlive the branches
+				start := int(node.StartByte())
+				c.skip(start, int(node.EndByte()))
+				c.output.insert("var "+strings.Join(ids, ", ")+";", start)
+				return
+			}
+			c.removeStatement(node)
+			return
+		}
+		removed := node.ChildByFieldName("alternative")
+		if !condition.truthy() {
+			removed = node.ChildByFieldName("consequence")
+		}
+		if removed != nil {
+			if ids, ok := hoistedBindings(removed, c.source); !ok || len(ids) > 0 {
+				break
+			}
+		}
+		c.replace(node, branch, false)
+		return
+	case "else_clause":
+		if !isDeadBranch(jsxExpressionInner(node), c.source) {
+			break
+		}
+		if ids, ok := hoistedBindings(node, c.source); !ok || len(ids) > 0 {
+			break
+		}
+		start := int(node.StartByte())
+		for start > c.cursor && isWhitespace(c.source[start-1]) {
+			start--
+		}
+		c.skip(start, int(node.EndByte()))
+		return
+	case "expression_statement":
+		expression := node.NamedChild(0)
+		if expression == nil {
+			break
+		}
+		folded := foldExpression(expression, c.source)
+		if folded.Equal(expression) {
+			break
+		}
+		if _, ok := constantValue(folded, c.source); ok {
+			c.removeStatement(node)
+			return
+		}
+	case "ternary_expression", "binary_expression":
+		folded := foldExpression(node, c.source)
+		if folded.Equal(node) {
+			break
+		}
+		c.replace(node, folded, needsParentheses(node, folded))
+		return
+	}
+	for i := 0; i < int(node.ChildCount()); i++ {
+		c.walk(node.Child(i))
+	}
+}
+
+// removes the code made unreachable by constant conditions from the result
+// of a compilation, its source map still refers to the compiled source
+func EliminateDeadCode(ctx context.Context, result Result, opts Options) (Result, error) {
+	parsed, err := Parse(ctx, []byte(result.Code), opts)
+	if err != nil {
+		return Result{}, err
+	}
+	if HasErrors(parsed.Diagnostics) {
+		// leaves code that can't be parsed as it is
+		return result, nil
+	}
+
+	c := &dceCtx{
+		source: parsed.Source,
+		output: newRewriter(parsed),
+	}
+	c.walk(parsed.Tree.RootNode())
+	c.output.keep(c.cursor, len(c.source))
+
+	if result.SourceMap != nil {
+		outer, err := c.output.sourceMap("")
+		if err != nil {
+			return Result{}, err
+		}
+		result.SourceMap, err = composeSourceMaps(outer, result.SourceMap)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+	result.Code = c.output.output.String()
+	return result, nil
+}
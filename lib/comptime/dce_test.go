@@ -0,0 +1,106 @@
+package comptime
+
+import (
+	"context"
+	"testing"
+)
+
+func TestEliminateDeadCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "taken branch",
+			source: "if (true) { f() } else { g() }\n",
+			want:   "{ f() }\n",
+		},
+		{
+			name:   "removed branch",
+			source: "a()\nif (false) { f() }\nb()\n",
+			want:   "a()\nb()\n",
+		},
+		{
+			name:   "else if",
+			source: "if (x) { f() } else if (0) { g() }\n",
+			want:   "if (x) { f() }\n",
+		},
+		{
+			name:   "branch required by the parent",
+			source: "while (x) if (false) f()\n",
+			want:   "while (x) {}\n",
+		},
+		{
+			name:   "logical operators",
+			source: "const a = true && x, b = null ?? y, c = 0 || z\n",
+			want:   "const a = x, b = y, c = z\n",
+		},
+		{
+			name:   "ternary",
+			source: "const a = \"\" ? f() : g()\n",
+			want:   "const a = g()\n",
+		},
+		{
+			name:   "constant statement",
+			source: "a()\ntrue && \"unused\"\nb()\n",
+			want:   "a()\nb()\n",
+		},
+		{
+			name:   "hoisted var",
+			source: "if (false) { var a = 1, { b } = o }\nconsole.log(a, b)\n",
+			want:   "var a, b;\nconsole.log(a, b)\n",
+		},
+		{
+			name:   "hoisted var in the branch not taken",
+			source: "if (true) { f() } else { var a = 1 }\n",
+			want:   "if (true) { f() } else { var a = 1 }\n",
+		},
+		{
+			name:   "function declared in a block",
+			source: "if (false) { function f() {} }\n",
+			want:   "if (false) { function f() {} }\n",
+		},
+		{
+			name:   "var in a nested function",
+			source: "if (false) { g(function () { var a }) }\n",
+			want:   "",
+		},
+		{
+			name:   "runtime condition",
+			source: "if (x === 1) { f() }\n",
+			want:   "if (x === 1) { f() }\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := EliminateDeadCode(context.Background(), Result{Code: test.source}, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Code != test.want {
+				t.Errorf("got:\n%s\nwant:\n%s", result.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestEliminateDeadCodeSourceMap(t *testing.T) {
+	source := "$comptime: const DEBUG = false\nif (DEBUG) { f() }\ng()\n"
+	result, _ := compile(t, source, map[string]string{"DEBUG": "false"}, Options{})
+	result, err := EliminateDeadCode(context.Background(), result, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != "\ng()\n" {
+		t.Fatalf("got %q", result.Code)
+	}
+	lines, err := decodeMappings(sourceMapMappings(t, result.SourceMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// g() is on the third line of the source
+	if len(lines) < 2 || len(lines[1]) == 0 || lines[1][0].sourceLine != 2 {
+		t.Errorf("g() is not mapped to the third line: %v", lines)
+	}
+}
@@ -0,0 +1,100 @@
+package comptime
+
+import (
+	"fmt"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+type Severity = uint8
+
+const (
+	SEVERITY_ERROR Severity = iota
+	SEVERITY_WARNING
+)
+
+// a position in the source, line and column are zero-based and column is in
+// bytes
+type Position struct {
+	Offset uint32 `json:"offset"`
+	Line   uint32 `json:"line"`
+	Column uint32 `json:"column"`
+}
+
+type Diagnostic struct {
+	Severity Severity
+	Message  string
+	Start    Position
+	End      Position
+}
+
+func startPosition(node *sitter.Node) Position {
+	point := node.StartPoint()
+	return Position{
+		Offset: node.StartByte(),
+		Line:   point.Row,
+		Column: point.Column,
+	}
+}
+
+func endPosition(node *sitter.Node) Position {
+	point := node.EndPoint()
+	return Position{
+		Offset: node.EndByte(),
+		Line:   point.Row,
+		Column: point.Column,
+	}
+}
+
+func nodeDiagnostic(severity Severity, node *sitter.Node, format string, args ...any) Diagnostic {
+	return Diagnostic{
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+		Start:    startPosition(node),
+		End:      endPosition(node),
+	}
+}
+
+// returns a diagnostic for every syntax error in the tree
+func syntaxDiagnostics(node *sitter.Node, source []byte) []Diagnostic {
+	if node.IsMissing() {
+		return []Diagnostic{nodeDiagnostic(
+			SEVERITY_ERROR, node,
+			"syntax error: missing %q", node.Type(),
+		)}
+	}
+	if node.IsError() {
+		return []Diagnostic{nodeDiagnostic(
+			SEVERITY_ERROR, node,
+			"syntax error: unexpected %q", node.Content(source),
+		)}
+	}
+
+	if !node.HasError() {
+		return nil
+	}
+
+	diagnostics := []Diagnostic{}
+	for i := 0; i < int(node.ChildCount()); i++ {
+		diagnostics = append(diagnostics, syntaxDiagnostics(node.Child(i), source)...)
+	}
+	return diagnostics
+}
+
+func (d Diagnostic) String() string {
+	severity := "error"
+	if d.Severity == SEVERITY_WARNING {
+		severity = "warning"
+	}
+	return fmt.Sprintf("%d:%d: %s: %s", d.Start.Line+1, d.Start.Column+1, severity, d.Message)
+}
+
+// returns true if any of the diagnostics is an error
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SEVERITY_ERROR {
+			return true
+		}
+	}
+	return false
+}
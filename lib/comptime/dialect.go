@@ -0,0 +1,132 @@
+package comptime
+
+import (
+	"path/filepath"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/javascript"
+	"github.com/smacker/go-tree-sitter/typescript/tsx"
+	"github.com/smacker/go-tree-sitter/typescript/typescript"
+)
+
+type Dialect = uint8
+
+const (
+	// javascript, jsx is also accepted by the javascript grammar
+	DIALECT_JS Dialect = iota
+	DIALECT_JSX
+	DIALECT_TS
+	DIALECT_TSX
+)
+
+// returns the dialect of a source file from its extension, defaults to
+// DIALECT_JS
+func DialectFromPath(path string) Dialect {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsx":
+		return DIALECT_JSX
+	case ".ts", ".mts", ".cts":
+		return DIALECT_TS
+	case ".tsx":
+		return DIALECT_TSX
+	}
+	return DIALECT_JS
+}
+
+// returns the dialect with the given name ("js", "jsx", "ts" or "tsx")
+func ParseDialect(name string) (Dialect, bool) {
+	switch strings.ToLower(name) {
+	case "js", "javascript":
+		return DIALECT_JS, true
+	case "jsx":
+		return DIALECT_JSX, true
+	case "ts", "typescript":
+		return DIALECT_TS, true
+	case "tsx":
+		return DIALECT_TSX, true
+	}
+	return DIALECT_JS, false
+}
+
+func (o Options) language() *sitter.Language {
+	switch o.Dialect {
+	case DIALECT_TS:
+		return typescript.GetLanguage()
+	case DIALECT_TSX:
+		return tsx.GetLanguage()
+	}
+	return javascript.GetLanguage()
+}
+
+// typescript-only syntax, this is removed from code sent to the comptime
+// environment as it can only execute javascript
+func isTypeOnly(node *sitter.Node) bool {
+	switch node.Type() {
+	case "type_annotation",
+		"type_arguments",
+		"type_parameters",
+		"implements_clause",
+		"accessibility_modifier",
+		"override_modifier",
+		"interface_declaration",
+		"type_alias_declaration",
+		"ambient_declaration":
+		return true
+	}
+	return false
+}
+
+// returns true for the typescript expressions asserting the type of the
+// expression they wrap
+func typeAssertion(nodeType string) bool {
+	switch nodeType {
+	case "as_expression", "satisfies_expression", "non_null_expression":
+		return true
+	}
+	return false
+}
+
+// returns the content of a node with typescript-only syntax removed
+func stripTypes(node *sitter.Node, source []byte) string {
+	out := strings.Builder{}
+	cursor := node.StartByte()
+
+	var walk func(n *sitter.Node)
+	skip := func(start, end uint32) {
+		out.Write(source[cursor:start])
+		cursor = end
+	}
+	walk = func(n *sitter.Node) {
+		if isTypeOnly(n) {
+			skip(n.StartByte(), n.EndByte())
+			return
+		}
+		switch n.Type() {
+		case "as_expression", "satisfies_expression", "non_null_expression":
+			// x as T, x satisfies T, x!
+			expr := n.NamedChild(0)
+			walk(expr)
+			skip(expr.EndByte(), n.EndByte())
+			return
+		case "optional_parameter":
+			// x?: T
+			for i := 0; i < int(n.ChildCount()); i++ {
+				child := n.Child(i)
+				if child.Type() == "?" {
+					skip(child.StartByte(), child.EndByte())
+					continue
+				}
+				walk(child)
+			}
+			return
+		}
+		for i := 0; i < int(n.ChildCount()); i++ {
+			walk(n.Child(i))
+		}
+	}
+	walk(node)
+	out.Write(source[cursor:node.EndByte()])
+
+	return out.String()
+}
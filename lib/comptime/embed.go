@@ -0,0 +1,80 @@
+package comptime
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+// the media types of the data urls of embedded files, by extension, other
+// files are embedded as application/octet-stream
+var embedTypes = map[string]string{
+	".avif":  "image/avif",
+	".bmp":   "image/bmp",
+	".css":   "text/css",
+	".csv":   "text/csv",
+	".gif":   "image/gif",
+	".htm":   "text/html",
+	".html":  "text/html",
+	".ico":   "image/x-icon",
+	".jpeg":  "image/jpeg",
+	".jpg":   "image/jpeg",
+	".js":    "text/javascript",
+	".json":  "application/json",
+	".mjs":   "text/javascript",
+	".mp3":   "audio/mpeg",
+	".mp4":   "video/mp4",
+	".otf":   "font/otf",
+	".pdf":   "application/pdf",
+	".png":   "image/png",
+	".svg":   "image/svg+xml",
+	".ttf":   "font/ttf",
+	".txt":   "text/plain",
+	".wasm":  "application/wasm",
+	".wav":   "audio/wav",
+	".webm":  "video/webm",
+	".webp":  "image/webp",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".xml":   "application/xml",
+}
+
+// returns the code of the object reflected as `$comptime.embed`, embedded
+// files are read with the fs module so that they are tracked as dependencies
+func embedPrelude(file string, opts Options) (string, error) {
+	// paths are resolved from the directory of the source file, or the
+	// working directory if it is unknown
+	dir := "."
+	if file != "" {
+		dir = filepath.Dir(file)
+	}
+	encodedDir, err := json.Marshal(dir)
+	if err != nil {
+		return "", err
+	}
+	types, err := json.Marshal(embedTypes)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(`(() => {
+  const fs = require("node:fs")
+  const path = require("node:path")
+  const dir = %s, limit = %d, types = %s
+  function read(file) {
+    const resolved = path.resolve(dir, file)
+    const size = fs.statSync(resolved).size
+    if (limit > 0 && size > limit) {
+      throw new Error(`+"`cannot embed ${file}: its size of ${size} bytes exceeds the limit of ${limit} bytes`"+`)
+    }
+    return fs.readFileSync(resolved)
+  }
+  return Object.freeze({
+    text: (file, encoding = "utf8") => read(file).toString(encoding),
+    base64: (file) => read(file).toString("base64"),
+    dataURL: (file, type = types[path.extname(file).toLowerCase()] ?? "application/octet-stream") =>
+      `+"`data:${type};base64,${read(file).toString(\"base64\")}`"+`,
+    json: (file) => JSON.parse(read(file).toString("utf8")),
+  })
+})()`, encodedDir, opts.EmbedLimit, types), nil
+}
@@ -0,0 +1,157 @@
+package comptime
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"jscomptime/lib/jsenv"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// returns the code of the function reflected as `$comptime.glob`, the
+// matching files are keyed by their path as written in the pattern
+func globPrelude(file string) (string, error) {
+	dir := "."
+	if file != "" {
+		dir = filepath.Dir(file)
+	}
+	encodedDir, err := json.Marshal(dir)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(`(() => {
+  const fs = require("node:fs")
+  const path = require("node:path")
+  const dir = %s
+  // converts a glob to a regular expression
+  function expression(glob) {
+    let source = "", braces = 0
+    for (let i = 0; i < glob.length; i++) {
+      const c = glob[i]
+      if (c === "*" && glob[i + 1] === "*") {
+        i++
+        if (glob[i + 1] === "/") {
+          // any number of directories
+          i++
+          source += "(?:[^/]*/)*"
+        } else {
+          source += ".*"
+        }
+      } else if (c === "*") {
+        source += "[^/]*"
+      } else if (c === "?") {
+        source += "[^/]"
+      } else if (c === "[") {
+        const end = glob.indexOf("]", i + 1)
+        if (end < 0) {
+          source += "\\["
+          continue
+        }
+        const negated = glob[i + 1] === "!"
+        source += "[" + (negated ? "^" : "") + glob.slice(negated ? i + 2 : i + 1, end).replace(/[\\^]/g, "\\$&") + "]"
+        i = end
+      } else if (c === "{") {
+        braces++
+        source += "(?:"
+      } else if (c === "}" && braces > 0) {
+        braces--
+        source += ")"
+      } else if (c === "," && braces > 0) {
+        source += "|"
+      } else {
+        source += c.replace(/[.+^${}()|[\]\\/]/g, "\\$&")
+      }
+    }
+    return new RegExp("^" + source + "$")
+  }
+  // returns the paths of the files below a directory, hidden directories and
+  // node_modules are skipped
+  function files(base, depth) {
+    const found = []
+    let entries
+    try {
+      entries = fs.readdirSync(path.resolve(dir, base), { withFileTypes: true })
+    } catch {
+      return found
+    }
+    for (const entry of entries) {
+      const child = base === "" ? entry.name : base + "/" + entry.name
+      if (entry.isDirectory()) {
+        if (depth > 1 && !entry.name.startsWith(".") && entry.name !== "node_modules") {
+          found.push(...files(child, depth - 1))
+        }
+      } else {
+        found.push(child)
+      }
+    }
+    return found
+  }
+  return (glob, { as, import: name = "*" } = {}) => {
+    const segments = glob.split("/")
+    const magic = segments.findIndex((segment) => /[*?[{]/.test(segment))
+    const base = segments.slice(0, magic < 0 ? segments.length - 1 : magic).join("/")
+    const depth = glob.includes("**") ? Infinity : segments.length - base.split("/").length + (base === "" ? 1 : 0)
+    const pattern = expression(glob)
+    const matches = files(base, depth).filter((file) => pattern.test(file)).sort()
+
+    const result = {}
+    for (const file of matches) {
+      if (as === undefined) {
+        // the output imports the module relative to itself
+        const specifier = /^\.{0,2}\//.test(file) ? file : "./" + file
+        result[file] = __jscomptime_import(specifier, name)
+      } else if (Object.hasOwn(%s.embed, as)) {
+        result[file] = %s.embed[as](file)
+      } else {
+        throw new Error(`+"`cannot expand ${glob} as ${as}, expected text, base64, dataURL or json`"+`)
+      }
+    }
+    return result
+  }
+})()`, encodedDir, REFLECTION_IDENTIFIER, REFLECTION_IDENTIFIER), nil
+}
+
+// returns the import statements of the modules referred to by the inlined
+// values, in the order their bindings were created
+func importDeclarations(imports []jsenv.Import) []string {
+	sorted := slices.Clone(imports)
+	slices.SortFunc(sorted, func(a, b jsenv.Import) int {
+		// bindings are numbered
+		if len(a.Binding) != len(b.Binding) {
+			return cmp.Compare(len(a.Binding), len(b.Binding))
+		}
+		return cmp.Compare(a.Binding, b.Binding)
+	})
+
+	declarations := make([]string, 0, len(sorted))
+	for _, imported := range sorted {
+		specifier, _ := json.Marshal(imported.Specifier)
+		var clause string
+		switch {
+		case imported.Name == "*":
+			clause = "* as " + imported.Binding
+		case imported.Name == "default":
+			clause = imported.Binding
+		case identifierPattern.MatchString(imported.Name):
+			clause = "{ " + imported.Name + " as " + imported.Binding + " }"
+		default:
+			name, _ := json.Marshal(imported.Name)
+			clause = "{ " + string(name) + " as " + imported.Binding + " }"
+		}
+		declarations = append(declarations, "import "+clause+" from "+string(specifier)+";")
+	}
+	return declarations
+}
+
+// returns the declarations inserted at the top of the output, they are
+// written on their own lines and end with a semicolon so that they are never
+// joined with the code that follows them
+func moduleHeader(declarations []string, afterDirectives bool) string {
+	if afterDirectives {
+		return "\n" + strings.Join(declarations, "\n")
+	}
+	return strings.Join(declarations, "\n") + "\n"
+}
@@ -0,0 +1,81 @@
+package comptime
+
+import (
+	"strconv"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+const HOIST_PREFIX = "__ct_"
+
+// values hoisted into module-level constants, identical values share the
+// same constant
+type hoistedValues struct {
+	// the name of the constant holding each value
+	names map[string]string
+	// the values in the order their constants are declared
+	values []string
+}
+
+// returns the values of the regions that are longer than Options.HoistSize
+func hoistValues(results *comptimeResults, opts Options) hoistedValues {
+	hoisted := hoistedValues{names: map[string]string{}}
+	if opts.HoistSize <= 0 {
+		return hoisted
+	}
+	for _, res := range results.defOrder {
+		if res.resultType != ct_type_region {
+			continue
+		}
+		region := results.regions[res.index]
+		// substitutions are folded into their template
+		if region.Node.Type() == "template_substitution" ||
+			len(region.Result) <= opts.HoistSize {
+			continue
+		}
+		if _, ok := hoisted.names[region.Result]; ok {
+			continue
+		}
+		hoisted.names[region.Result] = HOIST_PREFIX + strconv.Itoa(len(hoisted.values))
+		hoisted.values = append(hoisted.values, region.Result)
+	}
+	return hoisted
+}
+
+// returns the offset the imports and the constants are declared at, after
+// the hashbang and the directives starting the file
+func hoistOffset(root *sitter.Node, source []byte) int {
+	offset := 0
+	for i := 0; i < int(root.NamedChildCount()); i++ {
+		child := root.NamedChild(i)
+		switch child.Type() {
+		case "hash_bang_line":
+		case "expression_statement":
+			expression := child.NamedChild(0)
+			if expression == nil || expression.Type() != "string" {
+				return offset
+			}
+		case "comment":
+			continue
+		default:
+			return offset
+		}
+		offset = int(child.EndByte())
+	}
+	return offset
+}
+
+// returns the declarations of the constants
+func (h hoistedValues) declarations(opts Options) []string {
+	declarations := make([]string, 0, len(h.values))
+	for _, value := range h.values {
+		declaration := "const " + h.names[value] + " = "
+		if opts.Minify {
+			declaration += minifyValue(value, nil)
+		} else {
+			declaration += FormatValue(value, len(declaration), "", opts)
+		}
+		declarations = append(declarations, declaration+";")
+	}
+	return declarations
+}
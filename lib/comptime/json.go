@@ -1,57 +1,137 @@
 package comptime
 
+import (
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// the version of the JSON scope dump, bumped when its schema changes
+const JSON_SCOPE_VERSION = 2
+
+// a node of the source, positions are the ones used by diagnostics
+type JSONNode struct {
+	Text  string   `json:"text"`
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
 type JSONVarDeclarations struct {
+	JSONNode
 	Identifiers []string `json:"identifiers"`
-	Text        string   `json:"text"`
+}
+
+type JSONMacro struct {
+	JSONNode
+	// the runtime substitutions passed to the tag as placeholders
+	Placeholders []JSONNode `json:"placeholders"`
 }
 
 type JSONStatementRef struct {
-	Type  string `json:"type"`
-	Index int    `json:"index"`
+	// "scope", "statement", "declaration", "region", "jsx_expansion" or
+	// "macro"
+	Type string `json:"type"`
+	// the index in the list of the scope matching the type, "scope" refers
+	// to children
+	Index int `json:"index"`
 }
 
 type JSONScope struct {
-	Children        []JSONScope           `json:"children"`
-	DefinitionOrder []JSONStatementRef    `json:"def_order"`
-	Statements      []string              `json:"statements"`
+	// the type of the node that introduced the scope ("program",
+	// "statement_block", "function_declaration", ...)
+	Kind            string             `json:"kind"`
+	Start           Position           `json:"start"`
+	End             Position           `json:"end"`
+	Children        []JSONScope        `json:"children"`
+	DefinitionOrder []JSONStatementRef `json:"def_order"`
+	// the order in which the definitions are evaluated, comptime
+	// declarations come before the definitions depending on them
+	EvaluationOrder []JSONStatementRef    `json:"eval_order"`
+	Statements      []JSONNode            `json:"statements"`
 	Declarations    []JSONVarDeclarations `json:"declarations"`
-	Regions         []string              `json:"regions"`
+	Regions         []JSONNode            `json:"regions"`
+	JSXExpansions   []JSONNode            `json:"jsx_expansions"`
+	Macros          []JSONMacro           `json:"macros"`
+	// the identifiers declared by runtime code in the scope
+	RuntimeDeclarations []string `json:"runtime_declarations"`
 }
 
-func TransformToJSONScope(scope *Scope, source []byte) JSONScope {
-	definitionOrder := make([]JSONStatementRef, len(scope.DefinitionOrder))
-	for i, s := range scope.DefinitionOrder {
-		t := ""
-		switch s.Type {
-		case DEF_COMPTIME_STATEMENT:
-			t = "statement"
-		case DEF_COMPTIME_DECLARATION:
-			t = "declaration"
-		case DEF_REGION:
-			t = "region"
-		}
-		definitionOrder[i] = JSONStatementRef{
-			Type:  t,
+type JSONScopeDump struct {
+	Version  int       `json:"version"`
+	Filename string    `json:"filename"`
+	Scope    JSONScope `json:"scope"`
+}
+
+func definitionTypeName(t DefinitionType) string {
+	switch t {
+	case DEF_SCOPE:
+		return "scope"
+	case DEF_COMPTIME_STATEMENT:
+		return "statement"
+	case DEF_COMPTIME_DECLARATION:
+		return "declaration"
+	case DEF_REGION:
+		return "region"
+	case DEF_JSX_EXPANSION:
+		return "jsx_expansion"
+	case DEF_MACRO:
+		return "macro"
+	}
+	return ""
+}
+
+func jsonStatementRefs(refs []StatementRef) []JSONStatementRef {
+	jsonRefs := make([]JSONStatementRef, len(refs))
+	for i, s := range refs {
+		jsonRefs[i] = JSONStatementRef{
+			Type:  definitionTypeName(s.Type),
 			Index: s.Index,
 		}
 	}
+	return jsonRefs
+}
+
+func TransformToJSONScope(scope *Scope, source []byte) JSONScope {
 
-	statements := make([]string, len(scope.ComptimeStatements))
+	node := func(n *sitter.Node) JSONNode {
+		return JSONNode{
+			Text:  n.Content(source),
+			Start: startPosition(n),
+			End:   endPosition(n),
+		}
+	}
+
+	statements := make([]JSONNode, len(scope.ComptimeStatements))
 	for i, s := range scope.ComptimeStatements {
-		statements[i] = s.Content(source)
+		statements[i] = node(s)
 	}
 
 	declarations := make([]JSONVarDeclarations, len(scope.ComptimeDeclarations))
 	for i, d := range scope.ComptimeDeclarations {
 		declarations[i] = JSONVarDeclarations{
+			JSONNode:    node(d.Node),
 			Identifiers: d.Identifiers,
-			Text:        d.Node.Content(source),
 		}
 	}
 
-	regions := make([]string, len(scope.Regions))
+	regions := make([]JSONNode, len(scope.Regions))
 	for i, r := range scope.Regions {
-		regions[i] = r.Content(source)
+		regions[i] = node(r)
+	}
+
+	expansions := make([]JSONNode, len(scope.JSXExpansions))
+	for i, e := range scope.JSXExpansions {
+		expansions[i] = node(e.Node)
+	}
+
+	macros := make([]JSONMacro, len(scope.Macros))
+	for i, m := range scope.Macros {
+		placeholders := make([]JSONNode, len(m.Placeholders))
+		for j, p := range m.Placeholders {
+			placeholders[j] = node(p)
+		}
+		macros[i] = JSONMacro{
+			JSONNode:     node(m.Node),
+			Placeholders: placeholders,
+		}
 	}
 
 	children := make([]JSONScope, len(scope.Scopes))
@@ -59,10 +139,35 @@ func TransformToJSONScope(scope *Scope, source []byte) JSONScope {
 		children[i] = TransformToJSONScope(c, source)
 	}
 
-	return JSONScope{
-		Statements:      statements,
-		Declarations:    declarations,
-		Regions:         regions,
-		DefinitionOrder: definitionOrder,
+	runtimeDeclarations := scope.RuntimeDeclarations
+	if runtimeDeclarations == nil {
+		runtimeDeclarations = []string{}
+	}
+
+	jsonScope := JSONScope{
+		Children:            children,
+		DefinitionOrder:     jsonStatementRefs(scope.DefinitionOrder),
+		EvaluationOrder:     jsonStatementRefs(scope.evaluationOrder()),
+		Statements:          statements,
+		Declarations:        declarations,
+		Regions:             regions,
+		JSXExpansions:       expansions,
+		Macros:              macros,
+		RuntimeDeclarations: runtimeDeclarations,
+	}
+	if scope.Node != nil {
+		jsonScope.Kind = scope.Node.Type()
+		jsonScope.Start = startPosition(scope.Node)
+		jsonScope.End = endPosition(scope.Node)
+	}
+	return jsonScope
+}
+
+// returns the scope tree of an analysis in the JSON scope dump schema
+func DumpScopes(analysis *Analysis, opts Options) JSONScopeDump {
+	return JSONScopeDump{
+		Version:  JSON_SCOPE_VERSION,
+		Filename: opts.Filename,
+		Scope:    TransformToJSONScope(analysis.Root, analysis.Source),
 	}
 }
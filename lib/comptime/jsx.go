@@ -0,0 +1,271 @@
+package comptime
+
+import (
+	"fmt"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// returns true if the expression only depends on comptime values, this does
+// not record any regions or scopes in the given scope
+func isComptime(node *sitter.Node, scope *Scope, source []byte) bool {
+	probe := &Scope{Parent: scope}
+	return recurse(node, probe, source) == type_comptime
+}
+
+// jsx elements are never comptime as the comptime environment cannot evaluate
+// them, only their attributes and children are searched for regions
+func recurseJSX(node *sitter.Node, scope *Scope, source []byte) {
+	name := node.ChildByFieldName("name")
+	for i := 0; i < int(node.NamedChildCount()); i++ {
+		child := node.NamedChild(i)
+		if name != nil && child.Equal(name) {
+			continue
+		}
+
+		switch child.Type() {
+		case "jsx_opening_element",
+			"jsx_self_closing_element",
+			"jsx_element":
+			recurseJSX(child, scope, source)
+		case "jsx_expression":
+			handleJSXExpression(child, scope, source)
+		case "jsx_attribute":
+			value := child.NamedChild(int(child.NamedChildCount()) - 1)
+			switch value.Type() {
+			case "jsx_expression":
+				handleJSXExpression(value, scope, source)
+			case "jsx_element", "jsx_self_closing_element":
+				recurseJSX(value, scope, source)
+			}
+		}
+	}
+}
+
+// returns the expression inside of a jsx expression container `{expr}`
+func jsxExpressionInner(node *sitter.Node) *sitter.Node {
+	for i := 0; i < int(node.NamedChildCount()); i++ {
+		child := node.NamedChild(i)
+		if child.Type() != "comment" {
+			return child
+		}
+	}
+	return nil
+}
+
+func handleJSXExpression(node *sitter.Node, scope *Scope, source []byte) {
+	inner := jsxExpressionInner(node)
+	if inner == nil {
+		return
+	}
+
+	if inner.Type() == "spread_element" {
+		// {...props}
+		arg := inner.NamedChild(0)
+		if recurse(arg, scope, source) == type_comptime {
+			addComptimeRegions(arg, scope)
+		}
+		return
+	}
+
+	isChild := node.Parent() != nil && node.Parent().Type() == "jsx_element"
+	if isChild {
+		expansion, ok := mapExpansion(node, inner, scope, source)
+		if ok {
+			scope.addJSXExpansion(expansion)
+			return
+		}
+	}
+
+	if recurse(inner, scope, source) != type_comptime || !inlineable(inner.Type()) {
+		return
+	}
+	if isChild {
+		scope.addJSXExpansion(JSXExpansion{
+			Node:  node,
+			Array: inner,
+		})
+		return
+	}
+	scope.addRegion(inner)
+}
+
+// tries to treat `{array.map((params) => <element />)}` as a jsx expansion,
+// this is only possible when the array is comptime and every expression
+// container in the element only depends on the callback parameters and
+// other comptime values
+func mapExpansion(
+	node *sitter.Node,
+	inner *sitter.Node,
+	scope *Scope,
+	source []byte,
+) (JSXExpansion, bool) {
+	if inner.Type() != "call_expression" {
+		return JSXExpansion{}, false
+	}
+	function := inner.ChildByFieldName("function")
+	if function.Type() != "member_expression" ||
+		function.ChildByFieldName("property").Content(source) != "map" {
+		return JSXExpansion{}, false
+	}
+	args := inner.ChildByFieldName("arguments")
+	if args.Type() != "arguments" || args.NamedChildCount() != 1 {
+		return JSXExpansion{}, false
+	}
+	callback := args.NamedChild(0)
+	if callback.Type() != "arrow_function" {
+		return JSXExpansion{}, false
+	}
+
+	template := callback.ChildByFieldName("body")
+	for template.Type() == "parenthesized_expression" {
+		template = template.NamedChild(0)
+	}
+	switch template.Type() {
+	case "jsx_element", "jsx_self_closing_element":
+	default:
+		return JSXExpansion{}, false
+	}
+
+	array := function.ChildByFieldName("object")
+	if !isComptime(array, scope, source) {
+		return JSXExpansion{}, false
+	}
+
+	params := []string{}
+	if param := callback.ChildByFieldName("parameter"); param != nil {
+		params = append(params, param.Content(source))
+	} else {
+		formal := callback.ChildByFieldName("parameters")
+		for i := 0; i < int(formal.NamedChildCount()); i++ {
+			param := formal.NamedChild(i)
+			switch param.Type() {
+			case "required_parameter", "optional_parameter":
+				param = param.ChildByFieldName("pattern")
+			case "assignment_pattern":
+				param = param.ChildByFieldName("left")
+			}
+			params = append(params, getDeclaredVars(param, source)...)
+		}
+	}
+	paramScope := &Scope{
+		Parent: scope,
+		ComptimeDeclarations: []VarDeclarations{{
+			Identifiers: params,
+			Node:        callback,
+		}},
+	}
+
+	holes := jsxHoles(template)
+	for _, h := range holes {
+		if !isComptime(h, paramScope, source) {
+			return JSXExpansion{}, false
+		}
+	}
+
+	return JSXExpansion{
+		Node:     node,
+		Array:    array,
+		Callback: callback,
+		Template: template,
+		Holes:    holes,
+	}, true
+}
+
+// returns the expressions of all expression containers inside a jsx element
+// (in source order)
+func jsxHoles(node *sitter.Node) []*sitter.Node {
+	holes := []*sitter.Node{}
+	for i := 0; i < int(node.NamedChildCount()); i++ {
+		child := node.NamedChild(i)
+		switch child.Type() {
+		case "jsx_expression":
+			inner := jsxExpressionInner(child)
+			if inner == nil {
+				continue
+			}
+			if inner.Type() == "spread_element" {
+				inner = inner.NamedChild(0)
+			}
+			holes = append(holes, inner)
+		case "jsx_opening_element",
+			"jsx_self_closing_element",
+			"jsx_element",
+			"jsx_attribute":
+			holes = append(holes, jsxHoles(child)...)
+		}
+	}
+	return holes
+}
+
+// returns the source text of the elements of a serialized array literal,
+// false is returned if the text is not an array literal
+func splitArrayLiteral(text string) ([]string, bool) {
+	array, source := parseValue(text)
+	if array == nil || array.Type() != "array" {
+		return nil, false
+	}
+
+	elements := make([]string, array.NamedChildCount())
+	for i := 0; i < int(array.NamedChildCount()); i++ {
+		elements[i] = array.NamedChild(i).Content(source)
+	}
+	return elements, true
+}
+
+// writes the code that exports the value of a jsx expansion, for map
+// expansions this exports the values of every hole for each element
+func renderJSXExpansionExport(e JSXExpansion, source []byte) string {
+	array := stripTypes(e.Array, source)
+	if e.Callback == nil {
+		return array
+	}
+
+	params := e.Callback.ChildByFieldName("parameter")
+	if params == nil {
+		params = e.Callback.ChildByFieldName("parameters")
+	}
+	holes := make([]string, len(e.Holes))
+	for i, h := range e.Holes {
+		holes[i] = stripTypes(h, source)
+	}
+	return "(" + array + ").map(" +
+		stripTypes(params, source) +
+		" => [" + strings.Join(holes, ", ") + "])"
+}
+
+// renders the static jsx children that replace the expansion given its
+// evaluated result
+func renderJSXExpansion(e JSXExpansion, result string, source []byte) (string, error) {
+	elements, ok := splitArrayLiteral(result)
+	if !ok {
+		return "{" + result + "}", nil
+	}
+
+	out := strings.Builder{}
+	if e.Callback == nil {
+		for _, el := range elements {
+			out.WriteString("{" + el + "}")
+		}
+		return out.String(), nil
+	}
+
+	for _, el := range elements {
+		values, ok := splitArrayLiteral(el)
+		if !ok || len(values) != len(e.Holes) {
+			return "", fmt.Errorf(
+				"could not expand jsx element, unexpected result %s",
+				el,
+			)
+		}
+		cursor := e.Template.StartByte()
+		for i, h := range e.Holes {
+			out.Write(source[cursor:h.StartByte()])
+			out.WriteString(values[i])
+			cursor = h.EndByte()
+		}
+		out.Write(source[cursor:e.Template.EndByte()])
+	}
+	return out.String(), nil
+}
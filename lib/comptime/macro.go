@@ -0,0 +1,153 @@
+package comptime
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// the function creating the placeholders passed to macros in place of runtime
+// code, a placeholder is converted to its code
+const MACRO_PLACEHOLDER_FUNCTION = `function __jscomptime_placeholder(index, code, ast) {
+  return { index, code, ast, toString: () => code }
+}
+`
+
+// the syntax tree of the code passed to a macro
+type MacroAST struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+	// the name of the field of the parent the node is in, if any
+	Field    string     `json:"field,omitempty"`
+	Start    Position   `json:"start"`
+	End      Position   `json:"end"`
+	Children []MacroAST `json:"children"`
+}
+
+// returns the syntax tree of a node, only named nodes are kept
+func macroAST(node *sitter.Node, source []byte) MacroAST {
+	ast := MacroAST{
+		Type:     node.Type(),
+		Text:     node.Content(source),
+		Start:    startPosition(node),
+		End:      endPosition(node),
+		Children: []MacroAST{},
+	}
+	for i := 0; i < int(node.ChildCount()); i++ {
+		child := node.Child(i)
+		if !child.IsNamed() || child.Type() == "comment" {
+			continue
+		}
+		childAST := macroAST(child, source)
+		childAST.Field = node.FieldNameForChild(i)
+		ast.Children = append(ast.Children, childAST)
+	}
+	return ast
+}
+
+// macros are declared like comptime functions, calls to them are not
+// evaluated as values
+func handleMacroBody(node *sitter.Node, scope *Scope, source []byte) {
+	ids := definedIdentifiers(node, source)
+	if len(ids) == 0 {
+		handleComptimeBody(node, scope, source)
+		return
+	}
+	scope.addComptimeDeclaration(VarDeclarations{
+		Identifiers: ids,
+		Node:        node,
+		Macro:       true,
+	})
+}
+
+// returns true if the function called is declared with `$macro:`, it can be
+// declared after the call
+func isMacroCall(node *sitter.Node, scope *Scope, source []byte) bool {
+	callee := node.ChildByFieldName("function")
+	if callee.Type() != "identifier" {
+		return false
+	}
+	id := callee.Content(source)
+	for ; scope != nil; scope = scope.Parent {
+		switch {
+		case slices.Contains(scope.RuntimeDeclarations, id),
+			slices.Contains(scope.ComptimeIdentifiers, id):
+			return false
+		case slices.Contains(scope.MacroIdentifiers, id):
+			return true
+		}
+	}
+	return false
+}
+
+// returns the arguments of a macro call
+func macroArguments(node *sitter.Node) []*sitter.Node {
+	args := []*sitter.Node{}
+	arguments := node.ChildByFieldName("arguments")
+	for i := 0; i < int(arguments.NamedChildCount()); i++ {
+		arg := arguments.NamedChild(i)
+		if arg.Type() != "comment" {
+			args = append(args, arg)
+		}
+	}
+	return args
+}
+
+func renderPlaceholder(index int, node *sitter.Node, source []byte) string {
+	code, _ := json.Marshal(node.Content(source))
+	ast, _ := json.Marshal(macroAST(node, source))
+	return fmt.Sprintf("__jscomptime_placeholder(%d, %s, %s)", index, code, ast)
+}
+
+// renders the call of the tag or the function of a macro, runtime code is
+// replaced with placeholders
+func renderMacroCall(m Macro, source []byte) string {
+	callee := m.Node.ChildByFieldName("function")
+	arguments := m.Node.ChildByFieldName("arguments")
+
+	var out strings.Builder
+	out.WriteString(stripTypes(callee, source))
+
+	if arguments.Type() != "template_string" {
+		placeholders := make([]string, len(m.Arguments))
+		for i, arg := range m.Arguments {
+			placeholders[i] = renderPlaceholder(i, arg, source)
+		}
+		out.WriteString("(" + strings.Join(placeholders, ", ") + ")")
+		return out.String()
+	}
+
+	placeholders := map[uint32]bool{}
+	for _, p := range m.Placeholders {
+		placeholders[p.StartByte()] = true
+	}
+	cursor := arguments.StartByte()
+	for i, expr := range substitutions(arguments) {
+		out.Write(source[cursor:expr.StartByte()])
+		if placeholders[expr.StartByte()] {
+			out.WriteString(renderPlaceholder(i, expr, source))
+		} else {
+			out.WriteString(stripTypes(expr, source))
+		}
+		cursor = expr.EndByte()
+	}
+	out.Write(source[cursor:arguments.EndByte()])
+	return out.String()
+}
+
+// returns the code a macro expands to
+func macroCode(m Macro, value string) (string, error) {
+	var code string
+	err := json.Unmarshal([]byte(value), &code)
+	if err != nil {
+		start := m.Node.StartPoint()
+		return "", fmt.Errorf(
+			"%d:%d: a macro must return a string of code, got %s",
+			start.Row+1, start.Column+1, value,
+		)
+	}
+	return code, nil
+}
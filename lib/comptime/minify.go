@@ -0,0 +1,264 @@
+package comptime
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+var exponentPattern = regexp.MustCompile(`e\+?(-?)0*(\d)`)
+
+// returns the shortest form of a number literal
+func minifyNumber(literal string) string {
+	f, err := strconv.ParseFloat(literal, 64)
+	if err != nil || strings.ContainsAny(literal, "xXoObBn_") {
+		return literal
+	}
+
+	candidates := []string{
+		strconv.FormatFloat(f, 'f', -1, 64),
+		exponentPattern.ReplaceAllString(strconv.FormatFloat(f, 'e', -1, 64), "e$1$2"),
+	}
+	shortest := literal
+	for _, c := range candidates {
+		if strings.HasPrefix(c, "0.") {
+			c = c[1:]
+		}
+		if len(c) < len(shortest) {
+			shortest = c
+		}
+	}
+	return shortest
+}
+
+// returns a serialized value in its shortest form
+func minifyNode(node *sitter.Node, source []byte) string {
+	switch node.Type() {
+	case "object", "array":
+		children := make([]string, 0, node.NamedChildCount())
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			child := node.NamedChild(i)
+			if child.Type() == "comment" {
+				continue
+			}
+			children = append(children, minifyNode(child, source))
+		}
+		if node.Type() == "array" {
+			return "[" + strings.Join(children, ",") + "]"
+		}
+		return "{" + strings.Join(children, ",") + "}"
+	case "pair":
+		p := printer{source: source}
+		return p.key(node.ChildByFieldName("key")) + ":" +
+			minifyNode(node.ChildByFieldName("value"), source)
+	case "spread_element":
+		return "..." + minifyNode(node.NamedChild(0), source)
+	case "arguments":
+		children := make([]string, 0, node.NamedChildCount())
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			children = append(children, minifyNode(node.NamedChild(i), source))
+		}
+		return "(" + strings.Join(children, ",") + ")"
+	case "new_expression":
+		return "new " + node.ChildByFieldName("constructor").Content(source) +
+			minifyNode(node.ChildByFieldName("arguments"), source)
+	case "member_expression":
+		return minifyNode(node.ChildByFieldName("object"), source) + "." +
+			node.ChildByFieldName("property").Content(source)
+	case "call_expression":
+		return minifyNode(node.ChildByFieldName("function"), source) +
+			minifyNode(node.ChildByFieldName("arguments"), source)
+	case "parenthesized_expression":
+		return "(" + minifyNode(node.NamedChild(0), source) + ")"
+	case "arrow_function":
+		return node.ChildByFieldName("parameters").Content(source) + "=>" +
+			minifyNode(node.ChildByFieldName("body"), source)
+	case "statement_block", "lexical_declaration":
+		children := make([]string, 0, node.NamedChildCount())
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			children = append(children, minifyNode(node.NamedChild(i), source))
+		}
+		if node.Type() == "lexical_declaration" {
+			return node.Child(0).Content(source) + " " + strings.Join(children, ",")
+		}
+		return "{" + strings.Join(children, ";") + "}"
+	case "variable_declarator":
+		return node.ChildByFieldName("name").Content(source) + "=" +
+			minifyNode(node.ChildByFieldName("value"), source)
+	case "expression_statement":
+		return minifyNode(node.NamedChild(0), source)
+	case "return_statement":
+		return "return " + minifyNode(node.NamedChild(0), source)
+	case "true":
+		return "!0"
+	case "false":
+		return "!1"
+	case "undefined":
+		return "void 0"
+	case "number":
+		return minifyNumber(node.Content(source))
+	case "unary_expression":
+		// -0.5 is written as -.5
+		operator := node.ChildByFieldName("operator").Type()
+		argument := node.ChildByFieldName("argument")
+		if (operator == "-" || operator == "+") && argument.Type() == "number" {
+			return operator + minifyNumber(argument.Content(source))
+		}
+	}
+	return node.Content(source)
+}
+
+// returns true if an inlined value replacing the node must be a primary
+// expression (ex. the object of a member expression), values that don't
+// replace a node are never primary
+func needsPrimary(node *sitter.Node) bool {
+	if node == nil {
+		return false
+	}
+	parent := node.Parent()
+	if parent == nil {
+		return false
+	}
+	var field *sitter.Node
+	switch parent.Type() {
+	case "member_expression", "subscript_expression":
+		field = parent.ChildByFieldName("object")
+	case "call_expression":
+		field = parent.ChildByFieldName("function")
+	case "binary_expression":
+		if parent.ChildByFieldName("operator").Type() != "**" {
+			return false
+		}
+		field = parent.ChildByFieldName("left")
+	default:
+		return false
+	}
+	return field != nil && field.Equal(node)
+}
+
+// returns the shortest form of the value that replaces a region
+func minifyValue(value string, region *sitter.Node) string {
+	node, source := parseValue(value)
+	if node == nil {
+		return value
+	}
+	minified := minifyNode(node, source)
+	switch node.Type() {
+	case "true", "false", "undefined", "number", "unary_expression":
+		if needsPrimary(region) {
+			return "(" + minified + ")"
+		}
+	}
+	return minified
+}
+
+func isWhitespace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// returns true if every statement in the block is expunged (or is a block
+// which is emptied)
+func isEmptied(block *sitter.Node, expunged map[uint32]bool) bool {
+	for i := 0; i < int(block.NamedChildCount()); i++ {
+		child := block.NamedChild(i)
+		switch {
+		case child.Type() == "comment":
+		case expunged[child.StartByte()]:
+		case child.Type() == "statement_block" && isEmptied(child, expunged):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// returns true if a comment is kept in minified output, like licenses
+// (/*! ... */, @license, @preserve)
+func preservedComment(comment *sitter.Node, source []byte) bool {
+	text := comment.Content(source)
+	return strings.HasPrefix(text, "/*!") || strings.HasPrefix(text, "//!") ||
+		strings.Contains(text, "@license") || strings.Contains(text, "@preserve")
+}
+
+// returns true if a comment is on the lines right above a node, comments
+// separated by a blank line or trailing another statement are not attached
+func attachedComment(comment *sitter.Node, node *sitter.Node) bool {
+	if comment.EndPoint().Row+1 < node.StartPoint().Row {
+		return false
+	}
+	prev := comment.PrevSibling()
+	return prev == nil || prev.EndPoint().Row < comment.StartPoint().Row
+}
+
+// returns the range of source that is removed when expunging a statement
+// in minified output, this includes the comments attached to the statement,
+// the whitespace left behind and enclosing blocks that are left empty,
+// preserved comments and comments separated from the statement by a blank
+// line are kept as they may be licenses or file headers
+func expungedRange(
+	statement *sitter.Node,
+	expunged map[uint32]bool,
+	source []byte,
+) (uint32, uint32) {
+	node := statement
+	for {
+		parent := node.Parent()
+		if parent == nil || parent.Type() != "statement_block" ||
+			!isEmptied(parent, expunged) {
+			break
+		}
+		grandparent := parent.Parent()
+		if grandparent == nil {
+			break
+		}
+		switch grandparent.Type() {
+		case "program", "statement_block":
+			node = parent
+			continue
+		}
+		break
+	}
+
+	first := node
+	for prev := node.PrevSibling(); prev != nil && prev.Type() == "comment" &&
+		attachedComment(prev, first) && !preservedComment(prev, source); prev = prev.PrevSibling() {
+		first = prev
+	}
+	start := first.StartByte()
+	end := node.EndByte()
+	for next := node.NextSibling(); next != nil && next.Type() == "comment" &&
+		next.StartPoint().Row == node.EndPoint().Row; next = next.NextSibling() {
+		end = next.EndByte()
+	}
+
+	if prev := first.PrevSibling(); prev != nil && prev.Type() == "comment" {
+		// the line break ending a kept comment is kept, the whitespace
+		// following the statement is removed instead
+		start = prev.EndByte()
+		if int(start) < len(source) && source[start] == '\r' {
+			start++
+		}
+		if int(start) < len(source) && source[start] == '\n' {
+			start++
+		}
+		return start, skipWhitespace(source, end)
+	}
+	for start > 0 && isWhitespace(source[start-1]) {
+		start--
+	}
+	trailing := skipWhitespace(source, end)
+	if int(trailing) < len(source) && source[trailing] == '}' {
+		end = trailing
+	}
+	return start, end
+}
+
+// returns the end of the whitespace following the given offset
+func skipWhitespace(source []byte, offset uint32) uint32 {
+	for int(offset) < len(source) && isWhitespace(source[offset]) {
+		offset++
+	}
+	return offset
+}
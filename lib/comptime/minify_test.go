@@ -0,0 +1,56 @@
+package comptime
+
+import (
+	"testing"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+func TestMinifyNumber(t *testing.T) {
+	tests := map[string]string{
+		"1":        "1",
+		"1.50":     "1.5",
+		"0.5":      ".5",
+		"1000000":  "1e6",
+		"0.000001": "1e-6",
+		"123456":   "123456",
+		"0x10":     "0x10",
+		"10n":      "10n",
+		"1_000":    "1_000",
+		"1e+21":    "1e21",
+	}
+	for literal, want := range tests {
+		if got := minifyNumber(literal); got != want {
+			t.Errorf("minifyNumber(%q) = %q, want %q", literal, got, want)
+		}
+	}
+}
+
+func TestMinifyValue(t *testing.T) {
+	// the region is x in a call argument or in the object of a member
+	call, _ := parseValue("f(x)")
+	argument := call.ChildByFieldName("arguments").NamedChild(0)
+	member, _ := parseValue("x.y")
+	object := member.ChildByFieldName("object")
+
+	tests := []struct {
+		value  string
+		region *sitter.Node
+		want   string
+	}{
+		{`{"a": 1.50, "b": [true, false]}`, argument, "{a:1.5,b:[!0,!1]}"},
+		{`{"a-b": "c", "d": null}`, argument, `{"a-b":"c",d:null}`},
+		{"[1, [2, 3], {}]", argument, "[1,[2,3],{}]"},
+		{"undefined", argument, "void 0"},
+		{"true", object, "(!0)"},
+		{"1000", object, "(1e3)"},
+		{"-0.5", argument, "-.5"},
+		{"-0.5", object, "(-.5)"},
+		{`"s"`, object, `"s"`},
+	}
+	for _, test := range tests {
+		if got := minifyValue(test.value, test.region); got != test.want {
+			t.Errorf("minifyValue(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
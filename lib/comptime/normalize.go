@@ -0,0 +1,155 @@
+package comptime
+
+import (
+	"bytes"
+	"context"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// text inserted in the source by the normalization
+type insertion struct {
+	// the offset of the inserted text in the normalized source
+	offset int
+	length int
+}
+
+// returns the identifiers declared by every comptime label of the file
+func comptimeIdentifiers(node *sitter.Node, source []byte, ids map[string]bool) {
+	if node.Type() == "labeled_statement" &&
+		node.ChildByFieldName("label").Content(source) == COMPTIME_KEYWORD {
+		for _, id := range definedIdentifiers(node.ChildByFieldName("body"), source) {
+			ids[id] = true
+		}
+		return
+	}
+	for i := 0; i < int(node.NamedChildCount()); i++ {
+		comptimeIdentifiers(node.NamedChild(i), source, ids)
+	}
+}
+
+type expansionCtx struct {
+	source     []byte
+	ids        map[string]bool
+	cursor     int
+	output     *bytes.Buffer
+	insertions []insertion
+}
+
+// expands the shorthand properties `{ id }` that may refer to comptime
+// declarations into `{ id: id }` so that their value can be inlined
+func expandShorthandProperties(source []byte, root *sitter.Node, ids map[string]bool) ([]byte, []insertion) {
+	ctx := &expansionCtx{
+		source: source,
+		ids:    ids,
+		output: bytes.NewBuffer(make([]byte, 0, len(source))),
+	}
+	ctx.recurse(root)
+	ctx.output.Write(source[ctx.cursor:])
+	return ctx.output.Bytes(), ctx.insertions
+}
+
+func (c *expansionCtx) recurse(node *sitter.Node) {
+	if node.Type() == "shorthand_property_identifier" {
+		id := node.Content(c.source)
+		if !c.ids[id] {
+			return
+		}
+		c.output.Write(c.source[c.cursor:node.EndByte()])
+		c.cursor = int(node.EndByte())
+
+		expansion := ": " + id
+		c.insertions = append(c.insertions, insertion{
+			offset: c.output.Len(),
+			length: len(expansion),
+		})
+		c.output.WriteString(expansion)
+		return
+	}
+	for i := 0; i < int(node.NamedChildCount()); i++ {
+		c.recurse(node.NamedChild(i))
+	}
+}
+
+// rewrites the parsed source into a form that is simpler to analyze, the
+// source map of the compilation still refers to the source before it was
+// normalized
+func Normalize(ctx context.Context, parsed *Parsed, opts Options) (*Parsed, error) {
+	if HasErrors(parsed.Diagnostics) || parsed.original != nil {
+		return parsed, nil
+	}
+
+	ids := map[string]bool{REFLECTION_IDENTIFIER: true}
+	for name := range opts.Defines {
+		ids[name] = true
+	}
+	comptimeIdentifiers(parsed.Tree.RootNode(), parsed.Source, ids)
+	source, insertions := expandShorthandProperties(parsed.Source, parsed.Tree.RootNode(), ids)
+	if len(insertions) == 0 {
+		return parsed, nil
+	}
+
+	normalized, err := Parse(ctx, source, opts)
+	if err != nil {
+		return nil, err
+	}
+	normalized.Diagnostics = parsed.Diagnostics
+	normalized.original = parsed.Source
+	normalized.insertions = insertions
+	return normalized, nil
+}
+
+// returns the source before normalization
+func (p *Parsed) Original() []byte {
+	if p.original == nil {
+		return p.Source
+	}
+	return p.original
+}
+
+// converts an offset in the source to an offset in the source before
+// normalization, offsets inside inserted text are moved to its start
+func (p *Parsed) OriginalOffset(offset int) int {
+	shift := 0
+	for _, ins := range p.insertions {
+		if offset <= ins.offset {
+			break
+		}
+		shift += min(offset-ins.offset, ins.length)
+	}
+	return offset - shift
+}
+
+// converts an offset in the source before normalization to an offset in the
+// source, offsets at an insertion are kept before the inserted text
+func (p *Parsed) NormalizedOffset(offset int) int {
+	shift := 0
+	for _, ins := range p.insertions {
+		if offset+shift <= ins.offset {
+			break
+		}
+		shift += ins.length
+	}
+	return offset + shift
+}
+
+// converts the positions of diagnostics of the analysis to positions in the
+// source before normalization, the normalization never inserts line breaks
+func (p *Parsed) OriginalDiagnostics(diagnostics []Diagnostic) []Diagnostic {
+	if p.original == nil {
+		return diagnostics
+	}
+	position := func(pos Position) Position {
+		offset := uint32(p.OriginalOffset(int(pos.Offset)))
+		pos.Column -= pos.Offset - offset
+		pos.Offset = offset
+		return pos
+	}
+	converted := make([]Diagnostic, len(diagnostics))
+	for i, d := range diagnostics {
+		d.Start = position(d.Start)
+		d.End = position(d.End)
+		converted[i] = d
+	}
+	return converted
+}
@@ -0,0 +1,300 @@
+package comptime
+
+import (
+	"slices"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+/*
+comptime declarations can be referenced before they are declared, so the
+definitions of a scope are not always evaluated in the order they are found:
+- a definition is evaluated after the comptime declarations of the scope it
+  depends on, directly or through the functions it calls
+- a scope is evaluated after the declarations its definitions depend on
+- the references in a function are only needed once it is called, unless
+  the function is called right away or passed to a call, they are evaluated
+  first when possible but never make a cycle
+the definitions that do not depend on a later declaration keep their order.
+*/
+
+// the comptime declarations referenced by a node
+type references struct {
+	eager []*VarDeclarations
+	// referenced in functions that are not called right away
+	lazy []*VarDeclarations
+}
+
+type dependencyGraph struct {
+	source []byte
+	// the scope each comptime declaration is in
+	scopes map[*VarDeclarations]*Scope
+	// the references of each comptime declaration
+	references map[*VarDeclarations]references
+}
+
+// orders the evaluation of the definitions of every scope, a diagnostic is
+// returned for the declarations depending on each other
+func orderEvaluation(root *Scope, source []byte) []Diagnostic {
+	g := &dependencyGraph{
+		source:     source,
+		scopes:     map[*VarDeclarations]*Scope{},
+		references: map[*VarDeclarations]references{},
+	}
+	g.addDeclarations(root)
+	return g.order(root)
+}
+
+func (g *dependencyGraph) addDeclarations(scope *Scope) {
+	for i := range scope.ComptimeDeclarations {
+		g.scopes[&scope.ComptimeDeclarations[i]] = scope
+	}
+	for _, child := range scope.Scopes {
+		g.addDeclarations(child)
+	}
+}
+
+// returns true if the function is called right away or passed to a call
+func calledFunction(node *sitter.Node) bool {
+	parent := node.Parent()
+	for parent != nil && parent.Type() == "parenthesized_expression" {
+		node, parent = parent, parent.Parent()
+	}
+	if parent == nil {
+		return false
+	}
+	switch parent.Type() {
+	case "arguments":
+		return true
+	case "call_expression":
+		return parent.ChildByFieldName("function").Equal(node)
+	}
+	return false
+}
+
+// collects the comptime declarations referenced in a node, the identifiers
+// declared in the node shadow them
+func (g *dependencyGraph) collect(node *sitter.Node, scope *Scope, lazy bool, shadowed []string, refs *references) {
+	switch node.Type() {
+	case "identifier", "shorthand_property_identifier":
+		id := node.Content(g.source)
+		if slices.Contains(shadowed, id) {
+			return
+		}
+		decl := ResolveDeclaration(id, scope)
+		if decl == nil {
+			return
+		}
+		if lazy {
+			refs.lazy = append(refs.lazy, decl)
+		} else {
+			refs.eager = append(refs.eager, decl)
+		}
+		return
+	}
+
+	if createsScope(node.Type()) {
+		shadowed = append(slices.Clip(shadowed), scopeBindings(node, g.source)...)
+		lazy = lazy || (isFunction(node.Type()) && !calledFunction(node))
+	}
+	for i := 0; i < int(node.NamedChildCount()); i++ {
+		g.collect(node.NamedChild(i), scope, lazy, shadowed, refs)
+	}
+}
+
+func (g *dependencyGraph) declarationReferences(decl *VarDeclarations) references {
+	refs, ok := g.references[decl]
+	if ok {
+		return refs
+	}
+	scope := g.scopes[decl]
+	switch decl.Node.Type() {
+	case "lexical_declaration", "variable_declaration":
+		// a variable depends on itself when its value references it or a
+		// variable declared after it in the same statement
+		declared := []string{}
+		for i := 0; i < int(decl.Node.NamedChildCount()); i++ {
+			declarator := decl.Node.NamedChild(i)
+			if declarator.Type() != "variable_declarator" {
+				continue
+			}
+			if value := declarator.ChildByFieldName("value"); value != nil {
+				g.collect(value, scope, false, declared, &refs)
+			}
+			name := declarator.ChildByFieldName("name")
+			declared = append(declared, getDeclaredVars(name, g.source)...)
+			g.collect(name, scope, false, declared, &refs)
+		}
+	default:
+		// functions and classes can reference themselves
+		g.collect(decl.Node, scope, false, decl.Identifiers, &refs)
+	}
+	g.references[decl] = refs
+	return refs
+}
+
+// returns the declarations needed to evaluate a definition, the ones it
+// references eagerly and the ones they reference eagerly are required (true),
+// the ones referenced in the functions they contain are only needed once the
+// functions are called (false)
+func (g *dependencyGraph) needed(scope *Scope, ref StatementRef) map[*VarDeclarations]bool {
+	needed := map[*VarDeclarations]bool{}
+	var add func(decl *VarDeclarations, required bool)
+	add = func(decl *VarDeclarations, required bool) {
+		if was, ok := needed[decl]; ok && (was || !required) {
+			return
+		}
+		needed[decl] = required
+		refs := g.declarationReferences(decl)
+		for _, other := range refs.eager {
+			add(other, required)
+		}
+		for _, other := range refs.lazy {
+			add(other, false)
+		}
+	}
+
+	switch ref.Type {
+	case DEF_SCOPE:
+		child := scope.Scopes[ref.Index]
+		for _, childRef := range child.DefinitionOrder {
+			for decl, required := range g.needed(child, childRef) {
+				needed[decl] = needed[decl] || required
+			}
+		}
+		return needed
+	case DEF_COMPTIME_DECLARATION:
+		refs := g.declarationReferences(&scope.ComptimeDeclarations[ref.Index])
+		for _, decl := range refs.eager {
+			add(decl, true)
+		}
+		return needed
+	}
+
+	refs := references{}
+	g.collect(scope.definitionNode(ref), scope, false, nil, &refs)
+	for _, decl := range refs.eager {
+		add(decl, true)
+	}
+	return needed
+}
+
+// returns the definitions in an order where each definition comes after the
+// ones it waits for, the definitions that can't be ordered are left out
+func dependencyOrder(definitions []StatementRef, waiting []map[int]bool) ([]StatementRef, []bool) {
+	// the first definition that is not waiting for any other definition is
+	// evaluated next
+	order := []StatementRef{}
+	evaluated := make([]bool, len(definitions))
+	for len(order) < len(definitions) {
+		next := -1
+		for i := range definitions {
+			if evaluated[i] {
+				continue
+			}
+			ready := true
+			for j := range waiting[i] {
+				ready = ready && evaluated[j]
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			break
+		}
+		evaluated[next] = true
+		order = append(order, definitions[next])
+	}
+	return order, evaluated
+}
+
+// orders the definitions of a scope and of its children
+func (g *dependencyGraph) order(scope *Scope) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	for _, child := range scope.Scopes {
+		diagnostics = append(diagnostics, g.order(child)...)
+	}
+
+	definitions := scope.DefinitionOrder
+	// the definitions each definition waits for, and among them the ones
+	// it can't be evaluated without
+	waiting := make([]map[int]bool, len(definitions))
+	requiring := make([]map[int]bool, len(definitions))
+	declared := map[*VarDeclarations]int{}
+	for i, ref := range definitions {
+		if ref.Type == DEF_COMPTIME_DECLARATION {
+			declared[&scope.ComptimeDeclarations[ref.Index]] = i
+		}
+	}
+	reordered := false
+	for i, ref := range definitions {
+		waiting[i] = map[int]bool{}
+		requiring[i] = map[int]bool{}
+		for decl, required := range g.needed(scope, ref) {
+			j, ok := declared[decl]
+			if !ok {
+				continue
+			}
+			// function declarations are hoisted
+			switch decl.Node.Type() {
+			case "function_declaration", "generator_function_declaration":
+				continue
+			}
+			if !required && j == i {
+				// a function referencing the declaration it is assigned to
+				continue
+			}
+			// a definition requiring itself is part of a cycle
+			waiting[i][j] = true
+			if required {
+				requiring[i][j] = true
+			}
+			if j >= i {
+				reordered = true
+			}
+		}
+	}
+	if !reordered {
+		return diagnostics
+	}
+
+	// the declarations referenced by functions are evaluated first when
+	// possible, they are only needed once the functions are called
+	order, evaluated := dependencyOrder(definitions, waiting)
+	if len(order) < len(definitions) {
+		order, evaluated = dependencyOrder(definitions, requiring)
+	}
+	if len(order) == len(definitions) {
+		scope.EvaluationOrder = order
+		return diagnostics
+	}
+
+	cycle := []string{}
+	for i, ref := range definitions {
+		if !evaluated[i] && ref.Type == DEF_COMPTIME_DECLARATION {
+			cycle = append(cycle, scope.ComptimeDeclarations[ref.Index].Identifiers...)
+		}
+	}
+	for i, ref := range definitions {
+		if evaluated[i] || ref.Type != DEF_COMPTIME_DECLARATION {
+			continue
+		}
+		diagnostics = append(diagnostics, nodeDiagnostic(
+			SEVERITY_ERROR, scope.definitionNode(ref),
+			"comptime declarations depend on each other: %s",
+			strings.Join(cycle, ", "),
+		))
+	}
+	return diagnostics
+}
+
+// returns the order in which the definitions of the scope are evaluated
+func (s *Scope) evaluationOrder() []StatementRef {
+	if s.EvaluationOrder != nil {
+		return s.EvaluationOrder
+	}
+	return s.DefinitionOrder
+}
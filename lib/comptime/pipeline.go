@@ -0,0 +1,353 @@
+package comptime
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"jscomptime/lib/jsenv"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+/*
+a compilation is made of the following passes, each of them can be run on its
+own:
+- Parse: parses the source
+- Normalize: expands the shorthand properties that may refer to comptime
+  declarations
+- Analyze: builds the scope tree and orders its evaluation
+- Render: renders the comptime program
+- Evaluate: evaluates the comptime program
+- Rewrite: splices the evaluated values into the source
+- EliminateDeadCode: removes the code made unreachable by the inlined values,
+  only run when Options.DeadCode is set
+*/
+
+type Options struct {
+	// the language the source is written in
+	Dialect Dialect
+	// the column after which inlined objects and arrays are broken onto
+	// multiple lines, defaults to DEFAULT_WIDTH
+	Width int
+	// the string used for one level of indentation in inlined values,
+	// defaults to DEFAULT_INDENT
+	Indent string
+	// removes the whitespace, comments and blocks left behind by expunged
+	// comptime statements and inlines values in their shortest form
+	Minify bool
+	// removes the branches and operands made unreachable by conditions
+	// that are constant once values are inlined
+	DeadCode bool
+	// the path of the source file, used to resolve relative requires in
+	// comptime code and in the source map
+	Filename string
+	// the root of the project, defaults to the closest directory containing
+	// a package.json, reflected to comptime code
+	Root string
+	// values available to comptime code, serialized as JSON
+	Defines map[string]string
+	// the environment the output is built for, reflected to comptime code
+	Target string
+	// inlined values longer than this many bytes are hoisted into a
+	// module-level constant referenced by every region with the same value,
+	// values are not hoisted if it is 0
+	HoistSize int
+	// files embedded with `$comptime.embed` that are larger than this many
+	// bytes fail the compilation, the size of embedded files is not limited
+	// if it is 0
+	EmbedLimit int
+}
+
+type Result struct {
+	Code string
+	// a version 3 source map of the code
+	SourceMap []byte
+	// syntax errors and warnings, the code is not compiled if any of them
+	// is an error
+	Diagnostics []Diagnostic
+	// absolute paths of the files read by comptime code
+	Dependencies []string
+}
+
+// the comptime code of an analysis, the values it exports are stored in it
+// once it is evaluated
+type ComptimeProgram struct {
+	Analysis *Analysis
+	Code     string
+	results  *comptimeResults
+}
+
+func render(analysis *Analysis, exportBindings bool) (*ComptimeProgram, error) {
+	code := bytes.NewBuffer(nil)
+	results := &comptimeResults{exportBindings: exportBindings}
+	err := renderComptimeCode(analysis.Root, analysis.Source, results, code)
+	if err != nil {
+		return nil, err
+	}
+	// the values are spliced in the order of the source
+	slices.SortStableFunc(results.defOrder, func(a, b nodeRef) int {
+		return cmp.Compare(results.node(a).StartByte(), results.node(b).StartByte())
+	})
+	program := &ComptimeProgram{
+		Analysis: analysis,
+		Code:     code.String(),
+		results:  results,
+	}
+	if len(results.macros) > 0 {
+		program.Code = MACRO_PLACEHOLDER_FUNCTION + program.Code
+	}
+	return program, nil
+}
+
+// renders the comptime statements, declarations and regions of an analysis
+// into a program exporting the value of every region
+func Render(analysis *Analysis) (*ComptimeProgram, error) {
+	return render(analysis, false)
+}
+
+// evaluates a comptime program in the given environment
+func Evaluate(ctx context.Context, program *ComptimeProgram, env jsenv.Env, opts Options) (jsenv.Evaluation, error) {
+	prelude, err := reflectionPrelude(opts)
+	if err != nil {
+		return jsenv.Evaluation{}, err
+	}
+	evaluation, err := env.Eval(ctx, jsenv.Program{
+		Filename: opts.Filename,
+		Code:     prelude + program.Code,
+	}, program.results.regions)
+	if err != nil {
+		return jsenv.Evaluation{}, err
+	}
+	program.results.imports = evaluation.Imports
+	return evaluation, nil
+}
+
+// returns the expression statement the node is at the start of, or nil
+func leadingStatement(node *sitter.Node) *sitter.Node {
+	for parent := node.Parent(); parent != nil && parent.StartByte() == node.StartByte(); parent = parent.Parent() {
+		if parent.Type() == "expression_statement" {
+			return parent
+		}
+		node = parent
+	}
+	return nil
+}
+
+// returns true if the statement before this one doesn't end with a semicolon
+// or a block, so that a statement starting with ( or [ would continue it
+func continuesPrevious(statement *sitter.Node, expunged map[uint32]bool) bool {
+	parent := statement.Parent()
+	switch parent.Type() {
+	case "program", "statement_block", "switch_case", "switch_default":
+	default:
+		return false
+	}
+	prev := statement.PrevNamedSibling()
+	for prev != nil && (prev.Type() == "comment" || expunged[prev.StartByte()]) {
+		prev = prev.PrevNamedSibling()
+	}
+	if prev == nil {
+		return false
+	}
+	if value := parent.ChildByFieldName("value"); value != nil && value.Equal(prev) {
+		// the first statement of a case
+		return false
+	}
+
+	last := prev
+	for last.ChildCount() > 0 {
+		last = last.Child(int(last.ChildCount()) - 1)
+	}
+	switch last.Type() {
+	case ";":
+		return false
+	case "}":
+		switch last.Parent().Type() {
+		case "statement_block", "class_body", "switch_body":
+			return false
+		}
+	}
+	return true
+}
+
+// replaces the regions of an evaluated program with their values and removes
+// the comptime statements and declarations
+func Rewrite(program *ComptimeProgram, opts Options) (Result, error) {
+	results := program.results
+	source := program.Analysis.Source
+
+	expunged := map[uint32]bool{}
+	for _, statement := range results.statements {
+		expunged[statement.StartByte()] = true
+	}
+
+	output := newRewriter(program.Analysis.Parsed)
+	cursor := 0
+
+	hoisted := hoistValues(results, opts)
+	header := append(importDeclarations(results.imports), hoisted.declarations(opts)...)
+	if len(header) > 0 {
+		offset := hoistOffset(program.Analysis.Tree.RootNode(), source)
+		output.keep(0, offset)
+		output.insert(moduleHeader(header, offset > 0), offset)
+		cursor = offset
+	}
+
+	for _, res := range results.defOrder {
+		switch res.resultType {
+		case ct_type_region:
+			region := results.regions[res.index]
+
+			output.keep(cursor, int(region.Node.StartByte()))
+			var formatted string
+			var err error
+			if region.Node.Type() == "template_substitution" {
+				formatted, err = templateText(region.Result, region.Node, source)
+				if err != nil {
+					return Result{}, err
+				}
+			} else if name, ok := hoisted.names[region.Result]; ok {
+				formatted = name
+			} else if opts.Minify {
+				formatted = minifyValue(region.Result, region.Node)
+			} else {
+				start := region.Node.StartPoint()
+				formatted = FormatValue(
+					region.Result,
+					int(start.Column),
+					lineIndent(source, region.Node.StartByte()),
+					opts,
+				)
+			}
+			if start := region.Node.StartByte(); start > 0 && formatted != "" &&
+				(formatted[0] == '-' || formatted[0] == '+') && source[start-1] == formatted[0] {
+				// would be read as a decrement or an increment
+				formatted = " " + formatted
+			}
+			if statement := leadingStatement(region.Node); statement != nil {
+				if strings.HasPrefix(formatted, "{") {
+					// would be parsed as a block
+					formatted = "(" + formatted + ")"
+				}
+				if strings.HasPrefix(formatted, "(") || strings.HasPrefix(formatted, "[") {
+					if continuesPrevious(statement, expunged) {
+						// would be parsed as a call or a subscript of the
+						// previous statement
+						formatted = ";" + formatted
+					}
+				}
+			}
+			output.insert(formatted, int(region.Node.StartByte()))
+			cursor = int(region.Node.EndByte())
+		case ct_type_jsx_expansion:
+			expansion := results.expansions[res.index]
+			region := results.regions[expansion.regionId]
+
+			output.keep(cursor, int(region.Node.StartByte()))
+			children, err := renderJSXExpansion(expansion.expansion, region.Result, source)
+			if err != nil {
+				return Result{}, err
+			}
+			output.insert(children, int(region.Node.StartByte()))
+			cursor = int(region.Node.EndByte())
+		case ct_type_macro:
+			macro := results.macros[res.index]
+			region := results.regions[macro.regionId]
+
+			output.keep(cursor, int(region.Node.StartByte()))
+			code, err := macroCode(macro.macro, region.Result)
+			if err != nil {
+				return Result{}, err
+			}
+			output.insert(code, int(region.Node.StartByte()))
+			cursor = int(region.Node.EndByte())
+		case ct_type_statement:
+			statement := results.statements[res.index]
+			start, end := statement.StartByte(), statement.EndByte()
+			if opts.Minify {
+				if int(start) < cursor {
+					// already removed with the block enclosing it
+					continue
+				}
+				start, end = expungedRange(statement, expunged, source)
+				start = max(start, uint32(cursor))
+				if output.output.Len() == 0 {
+					// nothing is left before the statement
+					end = skipWhitespace(source, end)
+				}
+			}
+			output.keep(cursor, int(start))
+			cursor = int(end)
+		}
+	}
+	output.keep(cursor, len(source))
+
+	// sources are relative to the source map, which is expected to be next
+	// to the source file
+	filename := filepath.Base(opts.Filename)
+	if opts.Filename == "" {
+		filename = "<stdin>"
+	}
+	sourceMap, err := output.sourceMap(filename)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Code:        output.output.String(),
+		SourceMap:   sourceMap,
+		Diagnostics: program.Analysis.OriginalDiagnostics(program.Analysis.Diagnostics),
+	}, nil
+}
+
+func Compile(ctx context.Context, source []byte, env jsenv.Env, opts Options) (Result, error) {
+	parsed, err := Parse(ctx, source, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	if HasErrors(parsed.Diagnostics) {
+		return Result{
+			Code:        string(source),
+			Diagnostics: parsed.Diagnostics,
+		}, nil
+	}
+
+	parsed, err = Normalize(ctx, parsed, opts)
+	if err != nil {
+		return Result{}, err
+	}
+
+	analysis := Analyze(parsed, opts)
+	if HasErrors(analysis.Diagnostics) {
+		return Result{
+			Code:        string(source),
+			Diagnostics: analysis.OriginalDiagnostics(analysis.Diagnostics),
+		}, nil
+	}
+
+	program, err := Render(analysis)
+	if err != nil {
+		return Result{}, err
+	}
+
+	evaluation, err := Evaluate(ctx, program, env, opts)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result, err := Rewrite(program, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	if opts.DeadCode {
+		result, err = EliminateDeadCode(ctx, result, opts)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+	result.Dependencies = evaluation.Dependencies
+	return result, nil
+}
@@ -0,0 +1,253 @@
+package comptime
+
+import (
+	"context"
+	"fmt"
+	"jscomptime/lib/jsenv"
+	"strings"
+	"testing"
+)
+
+// an environment returning the value given for the source of each region
+// instead of running the comptime program
+type fakeEnv struct {
+	source []byte
+	values map[string]string
+	// the programs it was given
+	programs []string
+}
+
+func (env *fakeEnv) Eval(ctx context.Context, program jsenv.Program, results []jsenv.EvalResult) (jsenv.Evaluation, error) {
+	env.programs = append(env.programs, program.Code)
+	for i := range results {
+		text := results[i].Node.Content(env.source)
+		value, ok := env.values[text]
+		if !ok {
+			return jsenv.Evaluation{}, fmt.Errorf("no value for the region %q", text)
+		}
+		results[i].Result = value
+	}
+	return jsenv.Evaluation{}, nil
+}
+
+// runs every pass on a source, the regions evaluate to the given values
+func compile(t *testing.T, source string, values map[string]string, opts Options) (Result, *fakeEnv) {
+	t.Helper()
+	analysis := analyze(t, source, opts)
+	if HasErrors(analysis.Diagnostics) {
+		t.Fatalf("unexpected diagnostics: %v", analysis.Diagnostics)
+	}
+	program, err := Render(analysis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &fakeEnv{source: analysis.Source, values: values}
+	_, err = Evaluate(context.Background(), program, env, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Rewrite(program, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result, env
+}
+
+func TestRenderOrder(t *testing.T) {
+	analysis := analyze(t, "const f = () => g(v)\n$comptime: const v = 1", Options{})
+	program, err := Render(analysis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	declaration := strings.Index(program.Code, "const v = 1")
+	export := strings.Index(program.Code, "__jscomptime_export_value(0, v)")
+	if declaration < 0 || export < 0 || export < declaration {
+		t.Errorf("the region is not evaluated after the declaration:\n%s", program.Code)
+	}
+}
+
+func TestRenderTypeAssertions(t *testing.T) {
+	source := "$comptime: const n: number = 1\nf((n as number) + 1, n! + 1, (n satisfies number))"
+	analysis := analyze(t, source, Options{Dialect: DIALECT_TS})
+	program, err := Render(analysis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, syntax := range []string{": number", " as ", "!", "satisfies"} {
+		if strings.Contains(program.Code, syntax) {
+			t.Errorf("%q is not stripped:\n%s", syntax, program.Code)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		values map[string]string
+		opts   Options
+		want   string
+	}{
+		{
+			name:   "inlined value",
+			source: "$comptime: const a = 1\nf(a + 1)\n",
+			values: map[string]string{"a + 1": "2"},
+			want:   "\nf(2)\n",
+		},
+		{
+			name:   "wrapped object",
+			source: "$comptime: const o = {}\nconst value = o\n",
+			values: map[string]string{"o": `{"first": "aaaaaaaaaaaa", "second": "bbbbbbbbbbbb"}`},
+			opts:   Options{Width: 40},
+			want:   "\nconst value = {\n  first: \"aaaaaaaaaaaa\",\n  second: \"bbbbbbbbbbbb\"\n}\n",
+		},
+		{
+			name:   "minified",
+			source: "$comptime: const o = {}\nconst value = o\n",
+			values: map[string]string{"o": `{"a": 1.50, "b": [1, 2]}`},
+			opts:   Options{Minify: true},
+			want:   "const value = {a:1.5,b:[1,2]}\n",
+		},
+		{
+			name:   "minified with comments",
+			source: "/*! license */\n// header\n$comptime: const a = 1 // trailing\nf(a)\nif (x) {\n  // inside\n  $comptime: const b = 2\n}\n",
+			values: map[string]string{"a": "1"},
+			opts:   Options{Minify: true},
+			want:   "/*! license */\nf(1)\nif (x) {}\n",
+		},
+		{
+			name:   "minified with a file header",
+			source: "// header\n\n// about a\n$comptime: const a = 1\nf(a) // about f\n$comptime: const b = 2\n",
+			values: map[string]string{"a": "1"},
+			opts:   Options{Minify: true},
+			want:   "// header\nf(1) // about f\n",
+		},
+		{
+			name:   "template substitution",
+			source: "$comptime: const name = \"x\"\nconst s = `a ${name} ${b}`\n",
+			values: map[string]string{"${name}": `"x"`},
+			want:   "\nconst s = `a x ${b}`\n",
+		},
+		{
+			name:   "hoisted",
+			source: "$comptime: const o = {}\nf(o)\ng(o)\n",
+			values: map[string]string{"o": `{"a": "long enough"}`},
+			opts:   Options{HoistSize: 10},
+			want:   "const __ct_0 = { a: \"long enough\" };\n\nf(__ct_0)\ng(__ct_0)\n",
+		},
+		{
+			name:   "negative value after a minus",
+			source: "$comptime: const neg = -1\nf(a-neg, a - neg)\n",
+			values: map[string]string{"neg": "-1"},
+			want:   "\nf(a- -1, a - -1)\n",
+		},
+		{
+			name:   "minified negative value after a minus",
+			source: "$comptime: const neg = -1\nf(a-neg)\n",
+			values: map[string]string{"neg": "-1"},
+			opts:   Options{Minify: true},
+			want:   "f(a- -1)\n",
+		},
+		{
+			name:   "minified negative fraction",
+			source: "$comptime: const neg = -0.5\nf(a-neg, { neg })\n",
+			values: map[string]string{"neg": "-0.5", "{ neg: neg }": `{"neg": -0.50}`},
+			opts:   Options{Minify: true},
+			want:   "f(a- -.5, {neg:-.5})\n",
+		},
+		{
+			name:   "macro called before its declaration",
+			source: "const early = twice(y + 1)\n$macro: function twice(x) { return `(${x}) * 2` }\n",
+			values: map[string]string{"twice(y + 1)": `"(y + 1) * 2"`},
+			want:   "const early = (y + 1) * 2\n\n",
+		},
+		{
+			name:   "folded callback",
+			source: "$comptime: const o = { a: [1, 2] }\nconst doubled = o.a.map(x => x * 2)\n",
+			values: map[string]string{"o.a.map(x => x * 2)": "[2, 4]"},
+			want:   "\nconst doubled = [2, 4]\n",
+		},
+		{
+			name:   "type assertions",
+			source: "$comptime: const n = 42\nf((n as number) + 1, n! + 1, (n satisfies number), g(n as number))\n",
+			values: map[string]string{"(n as number) + 1": "43", "n! + 1": "43", "(n satisfies number)": "42", "n": "42"},
+			opts:   Options{Dialect: DIALECT_TS},
+			want:   "\nf(43, 43, 42, g(42 as number))\n",
+		},
+		{
+			name:   "object statement",
+			source: "$comptime: const o = {}\no\n",
+			values: map[string]string{"o": `{"a": 1}`},
+			want:   "\n({ a: 1 })\n",
+		},
+		{
+			name:   "object after a statement without a semicolon",
+			source: "$comptime: const o = {}\nx\no\n",
+			values: map[string]string{"o": `{"a": 1}`},
+			want:   "\nx\n;({ a: 1 })\n",
+		},
+		{
+			name:   "array after a statement without a semicolon",
+			source: "$comptime: const a = []\nx\na.forEach(f)\n",
+			values: map[string]string{"a": "[1, 2]"},
+			want:   "\nx\n;[1, 2].forEach(f)\n",
+		},
+		{
+			name:   "array after a statement ending with a semicolon",
+			source: "$comptime: const a = []\nx;\na.forEach(f)\nif (x) {}\na.forEach(f)\n",
+			values: map[string]string{"a": "[1, 2]"},
+			want:   "\nx;\n[1, 2].forEach(f)\nif (x) {}\n[1, 2].forEach(f)\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, _ := compile(t, test.source, test.values, test.opts)
+			if result.Code != test.want {
+				t.Errorf("got:\n%s\nwant:\n%s", result.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestCompileCycle(t *testing.T) {
+	sources := []string{
+		"$comptime: const a = b + 1\n$comptime: const b = a + 1\n",
+		"$comptime: const x = x + 1\n",
+		"$comptime: const y = z, z = 1\n",
+	}
+	for _, source := range sources {
+		result, err := Compile(context.Background(), []byte(source), &fakeEnv{}, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !HasErrors(result.Diagnostics) {
+			t.Errorf("the cycle is not reported: %q", source)
+			continue
+		}
+		if !strings.Contains(result.Diagnostics[0].Message, "depend on each other") {
+			t.Errorf("unexpected diagnostic: %s", result.Diagnostics[0].Message)
+		}
+		if result.Code != source {
+			t.Errorf("the source is not left as it is: %q", result.Code)
+		}
+	}
+}
+
+func TestCompileDiagnosticPositions(t *testing.T) {
+	// the shorthand property is expanded before the cycle on the same line
+	source := "$comptime: var p = 1\nf({ p }); $comptime: const x = x + 1\n"
+	result, err := Compile(context.Background(), []byte(source), &fakeEnv{}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic, got %v", result.Diagnostics)
+	}
+	d := result.Diagnostics[0]
+	if got := source[d.Start.Offset:d.End.Offset]; got != "const x = x + 1" {
+		t.Errorf("the diagnostic is not on the declaration: %q", got)
+	}
+	if d.Start.Line != 1 || d.Start.Column != uint32(strings.Index(source, "const x")-21) {
+		t.Errorf("unexpected position: %s", d)
+	}
+}
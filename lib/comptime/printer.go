@@ -0,0 +1,249 @@
+package comptime
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/javascript"
+)
+
+const (
+	DEFAULT_WIDTH  = 80
+	DEFAULT_INDENT = "  "
+)
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+type printer struct {
+	source []byte
+	width  int
+	indent string
+}
+
+// parses a serialized value, the returned node is the expression inside the
+// wrapping parenthesis, nil is returned if the value could not be parsed
+func parseValue(value string) (*sitter.Node, []byte) {
+	parser := sitter.NewParser()
+	parser.SetLanguage(javascript.GetLanguage())
+	source := []byte("(" + value + ")")
+	tree, err := parser.ParseCtx(context.Background(), nil, source)
+	if err != nil {
+		return nil, nil
+	}
+
+	root := tree.RootNode()
+	if root.HasError() || root.NamedChildCount() != 1 {
+		return nil, nil
+	}
+	statement := root.NamedChild(0)
+	if statement.Type() != "expression_statement" {
+		return nil, nil
+	}
+	expr := statement.NamedChild(0).NamedChild(0)
+	if expr == nil {
+		return nil, nil
+	}
+	return expr, source
+}
+
+// returns the leading whitespace of the line the given byte offset is on
+func lineIndent(source []byte, offset uint32) string {
+	start := int(offset)
+	for start > 0 && source[start-1] != '\n' {
+		start--
+	}
+	end := start
+	for end < len(source) && (source[end] == ' ' || source[end] == '\t') {
+		end++
+	}
+	return string(source[start:end])
+}
+
+// formats a serialized value so that it fits in the configured width when
+// inserted at the given column, lines after the first are indented relative
+// to the indentation of the line the value is inserted in
+func FormatValue(value string, column int, lineIndent string, opts Options) string {
+	node, source := parseValue(value)
+	if node == nil {
+		return value
+	}
+
+	p := printer{
+		source: source,
+		width:  opts.Width,
+		indent: opts.Indent,
+	}
+	if p.width <= 0 {
+		p.width = DEFAULT_WIDTH
+	}
+	if p.indent == "" {
+		p.indent = DEFAULT_INDENT
+	}
+	return p.print(node, column, lineIndent)
+}
+
+// returns a node on a single line
+func (p printer) flat(node *sitter.Node) string {
+	switch node.Type() {
+	case "object":
+		if node.NamedChildCount() == 0 {
+			return "{}"
+		}
+		return "{ " + strings.Join(p.flatChildren(node), ", ") + " }"
+	case "array":
+		return "[" + strings.Join(p.flatChildren(node), ", ") + "]"
+	case "pair":
+		return p.key(node.ChildByFieldName("key")) + ": " +
+			p.flat(node.ChildByFieldName("value"))
+	case "spread_element":
+		return "..." + p.flat(node.NamedChild(0))
+	case "arguments":
+		return "(" + strings.Join(p.flatChildren(node), ", ") + ")"
+	case "new_expression":
+		return "new " + node.ChildByFieldName("constructor").Content(p.source) +
+			p.flat(node.ChildByFieldName("arguments"))
+	case "member_expression":
+		return p.flat(node.ChildByFieldName("object")) + "." +
+			node.ChildByFieldName("property").Content(p.source)
+	case "call_expression":
+		return p.flat(node.ChildByFieldName("function")) +
+			p.flat(node.ChildByFieldName("arguments"))
+	case "parenthesized_expression":
+		return "(" + p.flat(node.NamedChild(0)) + ")"
+	// the statements below are found in the functions rebuilding shared
+	// objects
+	case "arrow_function":
+		return node.ChildByFieldName("parameters").Content(p.source) + " => " +
+			p.flat(node.ChildByFieldName("body"))
+	case "statement_block":
+		return "{ " + strings.Join(p.flatChildren(node), "; ") + " }"
+	case "lexical_declaration":
+		return node.Child(0).Content(p.source) + " " +
+			strings.Join(p.flatChildren(node), ", ")
+	case "variable_declarator":
+		return node.ChildByFieldName("name").Content(p.source) + " = " +
+			p.flat(node.ChildByFieldName("value"))
+	case "expression_statement":
+		return p.flat(node.NamedChild(0))
+	case "return_statement":
+		return "return " + p.flat(node.NamedChild(0))
+	}
+	return node.Content(p.source)
+}
+
+func (p printer) flatChildren(node *sitter.Node) []string {
+	children := make([]string, 0, node.NamedChildCount())
+	for i := 0; i < int(node.NamedChildCount()); i++ {
+		child := node.NamedChild(i)
+		if child.Type() == "comment" {
+			continue
+		}
+		children = append(children, p.flat(child))
+	}
+	return children
+}
+
+// string keys that are valid identifiers are written without quotes
+func (p printer) key(node *sitter.Node) string {
+	content := node.Content(p.source)
+	if node.Type() != "string" {
+		return content
+	}
+	unquoted := content[1 : len(content)-1]
+	if identifierPattern.MatchString(unquoted) {
+		return unquoted
+	}
+	return content
+}
+
+func (p printer) print(node *sitter.Node, column int, indent string) string {
+	flat := p.flat(node)
+	if column+len(flat) <= p.width {
+		return flat
+	}
+
+	open, close := "", ""
+	switch node.Type() {
+	case "object":
+		open, close = "{", "}"
+	case "array":
+		open, close = "[", "]"
+	case "pair":
+		key := p.key(node.ChildByFieldName("key")) + ": "
+		value := p.print(
+			node.ChildByFieldName("value"),
+			column+len(key),
+			indent,
+		)
+		return key + value
+	case "spread_element":
+		return "..." + p.print(node.NamedChild(0), column+3, indent)
+	case "arguments":
+		open, close = "(", ")"
+		// a single argument is not moved to its own line
+		if node.NamedChildCount() == 1 {
+			return open + p.print(node.NamedChild(0), column+1, indent) + close
+		}
+	case "new_expression":
+		constructor := "new " + node.ChildByFieldName("constructor").Content(p.source)
+		return constructor + p.print(
+			node.ChildByFieldName("arguments"),
+			column+len(constructor),
+			indent,
+		)
+	case "member_expression":
+		property := "." + node.ChildByFieldName("property").Content(p.source)
+		return p.print(node.ChildByFieldName("object"), column, indent) + property
+	case "call_expression":
+		function := p.print(node.ChildByFieldName("function"), column, indent)
+		column += len(function)
+		if newline := strings.LastIndexByte(function, '\n'); newline >= 0 {
+			column = len(function) - newline - 1
+		}
+		return function + p.print(node.ChildByFieldName("arguments"), column, indent)
+	case "parenthesized_expression":
+		return "(" + p.print(node.NamedChild(0), column+1, indent) + ")"
+	case "arrow_function":
+		head := node.ChildByFieldName("parameters").Content(p.source) + " => "
+		return head + p.print(node.ChildByFieldName("body"), column+len(head), indent)
+	case "statement_block":
+		inner := indent + p.indent
+		lines := []string{}
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			lines = append(lines, inner+p.print(node.NamedChild(i), len(inner), inner))
+		}
+		return "{\n" + strings.Join(lines, "\n") + "\n" + indent + "}"
+	case "lexical_declaration":
+		kind := node.Child(0).Content(p.source) + " "
+		declarators := []string{}
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			declarators = append(declarators, p.print(node.NamedChild(i), column+len(kind), indent))
+		}
+		return kind + strings.Join(declarators, ", ")
+	case "variable_declarator":
+		name := node.ChildByFieldName("name").Content(p.source) + " = "
+		return name + p.print(node.ChildByFieldName("value"), column+len(name), indent)
+	case "expression_statement":
+		return p.print(node.NamedChild(0), column, indent)
+	case "return_statement":
+		return "return " + p.print(node.NamedChild(0), column+len("return "), indent)
+	default:
+		return flat
+	}
+
+	inner := indent + p.indent
+	lines := []string{}
+	for i := 0; i < int(node.NamedChildCount()); i++ {
+		child := node.NamedChild(i)
+		if child.Type() == "comment" {
+			continue
+		}
+		lines = append(lines, inner+p.print(child, len(inner), inner))
+	}
+	if len(lines) == 0 {
+		return flat
+	}
+	return open + "\n" + strings.Join(lines, ",\n") + "\n" + indent + close
+}
@@ -0,0 +1,62 @@
+package comptime
+
+import "testing"
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		column int
+		indent string
+		opts   Options
+		want   string
+	}{
+		{
+			name:  "fits on one line",
+			value: `{"a": 1, "b": [1, 2], "c": {}}`,
+			want:  "{ a: 1, b: [1, 2], c: {} }",
+		},
+		{
+			name:  "quoted key",
+			value: `{"a-b": 1, "c": 2}`,
+			want:  `{ "a-b": 1, c: 2 }`,
+		},
+		{
+			name:   "broken at the width",
+			value:  `{"first": "aaaaaaaaaaaa", "second": [1, 2]}`,
+			column: 10,
+			opts:   Options{Width: 40},
+			want:   "{\n  first: \"aaaaaaaaaaaa\",\n  second: [1, 2]\n}",
+		},
+		{
+			name:   "indented relative to the line",
+			value:  `{"list": ["aaaaaaaaaaaa", "bbbbbbbbbbbb"]}`,
+			indent: "\t",
+			opts:   Options{Width: 30, Indent: "    "},
+			want:   "{\n\t    list: [\n\t        \"aaaaaaaaaaaa\",\n\t        \"bbbbbbbbbbbb\"\n\t    ]\n\t}",
+		},
+		{
+			name:  "not a literal",
+			value: "1 +",
+			want:  "1 +",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := FormatValue(test.value, test.column, test.indent, test.opts)
+			if got != test.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLineIndent(t *testing.T) {
+	source := []byte("a\n\t  f(x)\n")
+	if got := lineIndent(source, 6); got != "\t  " {
+		t.Errorf("got %q", got)
+	}
+	if got := lineIndent(source, 0); got != "" {
+		t.Errorf("got %q", got)
+	}
+}
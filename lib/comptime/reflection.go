@@ -0,0 +1,119 @@
+package comptime
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// the object describing where comptime code is running, it has the same name
+// as the comptime label
+const REFLECTION_IDENTIFIER = COMPTIME_KEYWORD
+
+// returns the closest directory containing a package.json, starting from the
+// directory of the file, the working directory is returned if there is none
+func projectRoot(filename string) (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	if filename == "" {
+		return cwd, nil
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		_, err := os.Stat(filepath.Join(dir, "package.json"))
+		if err == nil {
+			return dir, nil
+		}
+		if filepath.Dir(dir) == dir {
+			return cwd, nil
+		}
+	}
+}
+
+// returns the code declaring the defines and the reflection object, the
+// position of the definition being evaluated is updated with
+// `__jscomptime_locate`
+func reflectionPrelude(opts Options) (string, error) {
+	file := ""
+	if opts.Filename != "" {
+		var err error
+		file, err = filepath.Abs(opts.Filename)
+		if err != nil {
+			return "", err
+		}
+	}
+	root := opts.Root
+	if root == "" {
+		var err error
+		root, err = projectRoot(opts.Filename)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	bindings := strings.Builder{}
+	defines := []string{}
+	for _, name := range defineNames(opts) {
+		value := opts.Defines[name]
+		if !identifierPattern.MatchString(name) {
+			return "", fmt.Errorf("the name of the define %s is not an identifier", name)
+		}
+		if !json.Valid([]byte(value)) {
+			return "", fmt.Errorf("the value of the define %s is not valid JSON: %s", name, value)
+		}
+		fmt.Fprintf(&bindings, "const %s = %s\n", name, value)
+		defines = append(defines, name)
+	}
+
+	embed, err := embedPrelude(file, opts)
+	if err != nil {
+		return "", err
+	}
+
+	glob, err := globPrelude(file)
+	if err != nil {
+		return "", err
+	}
+
+	reflected, err := json.Marshal(map[string]string{
+		"file":   file,
+		"root":   root,
+		"target": opts.Target,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(`let __jscomptime_line = 0, __jscomptime_column = 0
+function __jscomptime_locate(line, column) {
+  __jscomptime_line = line
+  __jscomptime_column = column
+}
+%sconst %s = Object.freeze({
+  ...%s,
+  defines: Object.freeze({ %s }),
+  embed: %s,
+  glob: %s,
+  get line() { return __jscomptime_line },
+  get column() { return __jscomptime_column },
+})
+`, bindings.String(), REFLECTION_IDENTIFIER, reflected, strings.Join(defines, ", "), embed, glob), nil
+}
+
+// returns the names of the defines in a stable order
+func defineNames(opts Options) []string {
+	names := make([]string, 0, len(opts.Defines))
+	for name := range opts.Defines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,298 @@
+package comptime
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const base64Digits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// writes a base64 VLQ as defined by the source map v3 specification
+func writeVLQ(out *strings.Builder, value int) {
+	vlq := value << 1
+	if value < 0 {
+		vlq = (-value << 1) | 1
+	}
+	for {
+		digit := vlq & 31
+		vlq >>= 5
+		if vlq > 0 {
+			digit |= 32
+		}
+		out.WriteByte(base64Digits[digit])
+		if vlq == 0 {
+			return
+		}
+	}
+}
+
+// returns the length of text in utf-16 code units, which is what source map
+// columns are measured in
+func utf16Len(text []byte) int {
+	length := 0
+	for len(text) > 0 {
+		r, size := utf8.DecodeRune(text)
+		text = text[size:]
+		length++
+		if r >= 0x10000 {
+			length++
+		}
+	}
+	return length
+}
+
+// writes the output of a compilation while keeping track of where each piece
+// of the output came from in the source
+type rewriter struct {
+	parsed *Parsed
+	source []byte
+	// the start of the lines of the source before normalization
+	lineStarts []int
+	output     *bytes.Buffer
+
+	mappings strings.Builder
+	// position in the output
+	line   int
+	column int
+	// last values written to the mappings, the fields of a segment are
+	// relative to them
+	lastColumn       int
+	lastSourceLine   int
+	lastSourceColumn int
+	lineHasSegment   bool
+}
+
+func newRewriter(parsed *Parsed) *rewriter {
+	lineStarts := []int{0}
+	for i, b := range parsed.Original() {
+		if b == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	return &rewriter{
+		parsed:     parsed,
+		source:     parsed.Source,
+		lineStarts: lineStarts,
+		output:     bytes.NewBuffer(make([]byte, 0, len(parsed.Source))),
+	}
+}
+
+// returns the line and utf-16 column of an offset in the source, in the
+// source before normalization
+func (w *rewriter) sourcePosition(offset int) (int, int) {
+	offset = w.parsed.OriginalOffset(offset)
+	line := sort.Search(len(w.lineStarts), func(i int) bool {
+		return w.lineStarts[i] > offset
+	}) - 1
+	return line, utf16Len(w.parsed.Original()[w.lineStarts[line]:offset])
+}
+
+func (w *rewriter) addMapping(offset int) {
+	sourceLine, sourceColumn := w.sourcePosition(offset)
+	if w.lineHasSegment {
+		w.mappings.WriteByte(',')
+	}
+	writeVLQ(&w.mappings, w.column-w.lastColumn)
+	// there is only ever one source
+	writeVLQ(&w.mappings, 0)
+	writeVLQ(&w.mappings, sourceLine-w.lastSourceLine)
+	writeVLQ(&w.mappings, sourceColumn-w.lastSourceColumn)
+
+	w.lastColumn = w.column
+	w.lastSourceLine = sourceLine
+	w.lastSourceColumn = sourceColumn
+	w.lineHasSegment = true
+}
+
+// advances the output position over text, if offset is not negative every
+// new line is mapped to the source following the text at offset
+func (w *rewriter) advance(text []byte, offset int) {
+	for len(text) > 0 {
+		newline := bytes.IndexByte(text, '\n')
+		if newline < 0 {
+			w.column += utf16Len(text)
+			return
+		}
+		w.mappings.WriteByte(';')
+		w.line++
+		w.column = 0
+		w.lastColumn = 0
+		w.lineHasSegment = false
+		text = text[newline+1:]
+		if offset >= 0 {
+			offset += newline + 1
+			if len(text) > 0 {
+				w.addMapping(offset)
+			}
+		}
+	}
+}
+
+// copies source[start:end] to the output
+func (w *rewriter) keep(start, end int) {
+	if start >= end {
+		return
+	}
+	w.addMapping(start)
+	text := w.source[start:end]
+	w.output.Write(text)
+	w.advance(text, start)
+}
+
+// writes text that replaces the source at the given offset
+func (w *rewriter) insert(text string, offset int) {
+	if text == "" {
+		return
+	}
+	w.addMapping(offset)
+	w.output.WriteString(text)
+	w.advance([]byte(text), -1)
+}
+
+type sourceMap struct {
+	Version        int      `json:"version"`
+	Sources        []string `json:"sources"`
+	SourcesContent []string `json:"sourcesContent"`
+	Names          []string `json:"names"`
+	Mappings       string   `json:"mappings"`
+}
+
+// returns the source map of the output written so far
+func (w *rewriter) sourceMap(filename string) ([]byte, error) {
+	return json.Marshal(sourceMap{
+		Version:        3,
+		Sources:        []string{filename},
+		SourcesContent: []string{string(w.parsed.Original())},
+		Names:          []string{},
+		Mappings:       w.mappings.String(),
+	})
+}
+
+// reads a base64 VLQ, returns the value and the rest of the input
+func readVLQ(input string) (int, string, error) {
+	vlq := 0
+	shift := 0
+	for {
+		if input == "" {
+			return 0, "", fmt.Errorf("unterminated VLQ")
+		}
+		digit := strings.IndexByte(base64Digits, input[0])
+		if digit < 0 {
+			return 0, "", fmt.Errorf("invalid base64 digit %q", input[0])
+		}
+		input = input[1:]
+		vlq |= (digit & 31) << shift
+		shift += 5
+		if digit&32 == 0 {
+			break
+		}
+	}
+	if vlq&1 == 1 {
+		return -(vlq >> 1), input, nil
+	}
+	return vlq >> 1, input, nil
+}
+
+// a mapping of a source map with a single source
+type segment struct {
+	column       int
+	sourceLine   int
+	sourceColumn int
+}
+
+// decodes the mappings of a source map with a single source, indexed by line
+func decodeMappings(mappings string) ([][]segment, error) {
+	lines := [][]segment{}
+	sourceLine, sourceColumn := 0, 0
+	for _, line := range strings.Split(mappings, ";") {
+		segments := []segment{}
+		column := 0
+		for _, field := range strings.Split(line, ",") {
+			if field == "" {
+				continue
+			}
+			values := [4]int{}
+			rest := field
+			for i := range values {
+				var err error
+				values[i], rest, err = readVLQ(rest)
+				if err != nil {
+					return nil, err
+				}
+			}
+			column += values[0]
+			sourceLine += values[2]
+			sourceColumn += values[3]
+			segments = append(segments, segment{column, sourceLine, sourceColumn})
+		}
+		lines = append(lines, segments)
+	}
+	return lines, nil
+}
+
+// returns the source map of code transformed twice, outer maps the final code
+// to the intermediate code and inner maps the intermediate code to the source
+func composeSourceMaps(outer []byte, inner []byte) ([]byte, error) {
+	var outerMap, innerMap sourceMap
+	err := json.Unmarshal(outer, &outerMap)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(inner, &innerMap)
+	if err != nil {
+		return nil, err
+	}
+	outerLines, err := decodeMappings(outerMap.Mappings)
+	if err != nil {
+		return nil, err
+	}
+	innerLines, err := decodeMappings(innerMap.Mappings)
+	if err != nil {
+		return nil, err
+	}
+
+	var mappings strings.Builder
+	lastSourceLine, lastSourceColumn := 0, 0
+	for i, segments := range outerLines {
+		if i > 0 {
+			mappings.WriteByte(';')
+		}
+		lastColumn := 0
+		first := true
+		for _, s := range segments {
+			if s.sourceLine >= len(innerLines) {
+				continue
+			}
+			// the inner segment covering the intermediate position
+			candidates := innerLines[s.sourceLine]
+			j := sort.Search(len(candidates), func(j int) bool {
+				return candidates[j].column > s.sourceColumn
+			}) - 1
+			if j < 0 {
+				continue
+			}
+			covering := candidates[j]
+			sourceLine := covering.sourceLine
+			sourceColumn := covering.sourceColumn + s.sourceColumn - covering.column
+
+			if !first {
+				mappings.WriteByte(',')
+			}
+			writeVLQ(&mappings, s.column-lastColumn)
+			writeVLQ(&mappings, 0)
+			writeVLQ(&mappings, sourceLine-lastSourceLine)
+			writeVLQ(&mappings, sourceColumn-lastSourceColumn)
+			lastColumn = s.column
+			lastSourceLine = sourceLine
+			lastSourceColumn = sourceColumn
+			first = false
+		}
+	}
+
+	innerMap.Mappings = mappings.String()
+	return json.Marshal(innerMap)
+}
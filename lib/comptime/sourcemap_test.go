@@ -0,0 +1,69 @@
+package comptime
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// returns the mappings of a source map
+func sourceMapMappings(t *testing.T, data []byte) string {
+	t.Helper()
+	var m sourceMap
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.Mappings
+}
+
+func TestVLQ(t *testing.T) {
+	for _, value := range []int{0, 1, -1, 15, 16, -16, 1000, -123456} {
+		var out strings.Builder
+		writeVLQ(&out, value)
+		got, rest, err := readVLQ(out.String())
+		if err != nil || got != value || rest != "" {
+			t.Errorf("%d: got %d, %q, %v", value, got, rest, err)
+		}
+	}
+}
+
+func TestComposeSourceMaps(t *testing.T) {
+	// inner maps the intermediate code to the source: its first line comes
+	// from the second line of the source, shifted by 4 columns
+	inner, _ := json.Marshal(sourceMap{
+		Version:  3,
+		Sources:  []string{"a.js"},
+		Names:    []string{},
+		Mappings: "AACI",
+	})
+	// outer maps the final code to the intermediate code: its second line
+	// comes from the first line, 2 columns in
+	outer, _ := json.Marshal(sourceMap{
+		Version:  3,
+		Sources:  []string{""},
+		Names:    []string{},
+		Mappings: ";AAAE",
+	})
+
+	composed, err := composeSourceMaps(outer, inner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m sourceMap
+	err = json.Unmarshal(composed, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Sources) != 1 || m.Sources[0] != "a.js" {
+		t.Errorf("got sources %v", m.Sources)
+	}
+	lines, err := decodeMappings(m.Mappings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := segment{column: 0, sourceLine: 1, sourceColumn: 6}
+	if len(lines) != 2 || len(lines[0]) != 0 || len(lines[1]) != 1 || lines[1][0] != want {
+		t.Errorf("got %q: %v, want %v on the second line", m.Mappings, lines, want)
+	}
+}
@@ -0,0 +1,107 @@
+package comptime
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// returns the expressions of the substitutions of a template string
+func substitutions(template *sitter.Node) []*sitter.Node {
+	exprs := []*sitter.Node{}
+	for i := 0; i < int(template.NamedChildCount()); i++ {
+		child := template.NamedChild(i)
+		if child.Type() != "template_substitution" {
+			continue
+		}
+		expr := jsxExpressionInner(child)
+		if expr != nil {
+			exprs = append(exprs, expr)
+		}
+	}
+	return exprs
+}
+
+// a template string is comptime if all of its substitutions are, otherwise
+// its comptime substitutions are folded into its text
+func recurseTemplate(node *sitter.Node, scope *Scope, childScope *Scope, source []byte) childType {
+	comptimeSubstitutions := []*sitter.Node{}
+	hasRuntime := false
+	for _, expr := range substitutions(node) {
+		if recurse(expr, childScope, source) == type_comptime {
+			comptimeSubstitutions = append(comptimeSubstitutions, expr.Parent())
+		} else {
+			hasRuntime = true
+		}
+	}
+
+	if !hasRuntime {
+		return type_comptime
+	}
+	for _, substitution := range comptimeSubstitutions {
+		scope.addRegion(substitution)
+	}
+	return type_runtime
+}
+
+// a tagged template is comptime if its tag and all of its substitutions are,
+// it is a macro if only its tag is
+func recurseTaggedTemplate(node *sitter.Node, scope *Scope, childScope *Scope, source []byte) childType {
+	tag := node.ChildByFieldName("function")
+	template := node.ChildByFieldName("arguments")
+
+	if recurse(tag, childScope, source) != type_comptime {
+		// the substitutions are passed to the runtime tag as values
+		for _, expr := range substitutions(template) {
+			if recurse(expr, childScope, source) == type_comptime {
+				addComptimeRegions(expr, scope)
+			}
+		}
+		return type_runtime
+	}
+
+	placeholders := []*sitter.Node{}
+	for _, expr := range substitutions(template) {
+		if !isComptime(expr, childScope, source) {
+			placeholders = append(placeholders, expr)
+		}
+	}
+	if len(placeholders) == 0 {
+		return type_comptime
+	}
+	scope.addMacro(Macro{
+		Node:         node,
+		Placeholders: placeholders,
+	})
+	return type_runtime
+}
+
+var templateEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"`", "\\`",
+	"${", "\\${",
+)
+
+// converts the serialized value of a template substitution to the text of a
+// template string, the text must not form a `${` with the characters around
+// the substitution
+func templateText(value string, substitution *sitter.Node, source []byte) (string, error) {
+	var text string
+	err := json.Unmarshal([]byte(value), &text)
+	if err != nil {
+		return "", fmt.Errorf("template substitution is not a string: %s", value)
+	}
+	text = templateEscaper.Replace(text)
+
+	start, end := substitution.StartByte(), substitution.EndByte()
+	if start > 0 && source[start-1] == '$' && strings.HasPrefix(text, "{") {
+		text = "\\" + text
+	}
+	if int(end) < len(source) && source[end] == '{' && strings.HasSuffix(text, "$") &&
+		!strings.HasSuffix(text, "\\$") {
+		text = text[:len(text)-1] + "\\$"
+	}
+	return text, nil
+}
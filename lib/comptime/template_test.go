@@ -0,0 +1,59 @@
+package comptime
+
+import (
+	"context"
+	"testing"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// parses a source made of a template string and returns its first
+// substitution, the region of a substitution is the whole `${...}`
+func parseSubstitution(t *testing.T, source string) (*sitter.Node, []byte) {
+	t.Helper()
+	parsed, err := Parse(context.Background(), []byte(source), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := parsed.Tree.RootNode().NamedChild(0).NamedChild(0)
+	for i := 0; i < int(template.NamedChildCount()); i++ {
+		if template.NamedChild(i).Type() == "template_substitution" {
+			return template.NamedChild(i), parsed.Source
+		}
+	}
+	t.Fatalf("no substitution in %q", source)
+	return nil, nil
+}
+
+func TestTemplateText(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		value  string
+		want   string
+	}{
+		{"plain", "`a ${x} b`", `"text"`, "text"},
+		{"backtick and backslash", "`${x}`", `"a` + "`" + `b\\c"`, "a\\`b\\\\c"},
+		{"substitution", "`${x}`", `"${y}"`, "\\${y}"},
+		{"dollar before", "`$${x}`", `"{y}"`, "\\{y}"},
+		{"brace after", "`${x}{y}`", `"a$"`, "a\\$"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			substitution, source := parseSubstitution(t, test.source)
+			got, err := templateText(test.value, substitution, source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	substitution, source := parseSubstitution(t, "`${x}`")
+	_, err := templateText("1", substitution, source)
+	if err == nil {
+		t.Errorf("a value that is not a string is accepted")
+	}
+}
@@ -11,12 +11,46 @@ const (
 	DEF_COMPTIME_STATEMENT
 	DEF_COMPTIME_DECLARATION
 	DEF_REGION
+	DEF_JSX_EXPANSION
+	DEF_MACRO
 )
 
 type VarDeclarations struct {
 	Identifiers []string
 	// this should include the entire variable declaration
 	Node *sitter.Node
+	// declared with the `$macro:` label, calls to it in runtime code are
+	// replaced with the code it returns
+	Macro bool
+}
+
+// a jsx expression container in a child position that is replaced by the
+// static children it evaluates to
+type JSXExpansion struct {
+	// the expression container `{...}` that is replaced
+	Node *sitter.Node
+	// the comptime array (or value) being expanded
+	Array *sitter.Node
+	// the arrow function passed to `Array.map(...)`, nil if the elements of
+	// the array are inlined directly
+	Callback *sitter.Node
+	// the jsx element returned by the callback
+	Template *sitter.Node
+	// the expressions inside the template's expression containers
+	Holes []*sitter.Node
+}
+
+// a tagged template whose tag is comptime but some substitutions are not, or
+// a call to a function declared with `$macro:`, the tag or the function is
+// called at comptime and returns the code replacing the node
+type Macro struct {
+	// the tagged template or the call
+	Node *sitter.Node
+	// the runtime substitutions of a tagged template, they are passed to the
+	// tag as placeholders
+	Placeholders []*sitter.Node
+	// the arguments of a call, they are all passed as placeholders
+	Arguments []*sitter.Node
 }
 
 type StatementRef struct {
@@ -27,6 +61,9 @@ type StatementRef struct {
 type Scope struct {
 	// the parent scope
 	Parent *Scope
+	// the node that introduced the scope (the program, a block or a
+	// function)
+	Node *sitter.Node
 	// a list of children scopes
 	Scopes []*Scope
 	// a list that keeps order in which statements, declarations,
@@ -39,8 +76,29 @@ type Scope struct {
 	RuntimeDeclarations []string
 	// comptime variable declarations
 	ComptimeDeclarations []VarDeclarations
+	// the identifiers of the comptime declarations of the scope, they are
+	// collected when the scope is created so that they can be referenced
+	// before they are declared
+	ComptimeIdentifiers []string
+	// the identifiers of the macros of the scope, collected like
+	// ComptimeIdentifiers
+	MacroIdentifiers []string
 	// expressions in which comptime variables are used
 	Regions []*sitter.Node
+	// jsx children that are expanded with comptime values
+	JSXExpansions []JSXExpansion
+	// tagged templates expanded by their comptime tag
+	Macros []Macro
+	// comptime bindings given in the options, only the root scope has them
+	Defines []string
+	// the order in which the definitions are evaluated, definitions are
+	// moved after the comptime declarations they depend on, nil if it is
+	// the order of DefinitionOrder
+	EvaluationOrder []StatementRef
+	// the scopes of the functions passed to a call that may be folded, by
+	// the start of the function, they are added with the regions of the
+	// call when it is not folded
+	closures map[uint32]*Scope
 }
 
 func (s *Scope) addScope(scope *Scope) {
@@ -51,6 +109,24 @@ func (s *Scope) addScope(scope *Scope) {
 	})
 }
 
+func (s *Scope) setClosure(function *sitter.Node, scope *Scope) {
+	if s.closures == nil {
+		s.closures = map[uint32]*Scope{}
+	}
+	s.closures[function.StartByte()] = scope
+}
+
+// adds the scope of a function passed to a call that is not folded
+func (s *Scope) addClosure(function *sitter.Node) {
+	scope, ok := s.closures[function.StartByte()]
+	if !ok {
+		return
+	}
+	if len(scope.DefinitionOrder) > 0 || len(scope.RuntimeDeclarations) > 0 {
+		s.addScope(scope)
+	}
+}
+
 func (s *Scope) addComptimeStatement(statement *sitter.Node) {
 	s.ComptimeStatements = append(s.ComptimeStatements, statement)
 	s.DefinitionOrder = append(s.DefinitionOrder, StatementRef{
@@ -67,12 +143,63 @@ func (s *Scope) addComptimeDeclaration(decl VarDeclarations) {
 	})
 }
 
+// returns the node a definition was made from
+func (s *Scope) definitionNode(ref StatementRef) *sitter.Node {
+	switch ref.Type {
+	case DEF_SCOPE:
+		return s.Scopes[ref.Index].Node
+	case DEF_COMPTIME_STATEMENT:
+		return s.ComptimeStatements[ref.Index]
+	case DEF_COMPTIME_DECLARATION:
+		return s.ComptimeDeclarations[ref.Index].Node
+	case DEF_REGION:
+		return s.Regions[ref.Index]
+	case DEF_JSX_EXPANSION:
+		return s.JSXExpansions[ref.Index].Node
+	case DEF_MACRO:
+		return s.Macros[ref.Index].Node
+	}
+	return nil
+}
+
+// regions are found after the definitions nested in their siblings, they are
+// moved before the definitions that follow them in the source
+func (s *Scope) addOrdered(ref StatementRef, node *sitter.Node) {
+	i := len(s.DefinitionOrder)
+	for i > 0 {
+		previous := s.definitionNode(s.DefinitionOrder[i-1])
+		if previous == nil || previous.StartByte() < node.StartByte() {
+			break
+		}
+		i--
+	}
+	s.DefinitionOrder = append(s.DefinitionOrder, StatementRef{})
+	copy(s.DefinitionOrder[i+1:], s.DefinitionOrder[i:])
+	s.DefinitionOrder[i] = ref
+}
+
 func (s *Scope) addRegion(r *sitter.Node) {
 	s.Regions = append(s.Regions, r)
-	s.DefinitionOrder = append(s.DefinitionOrder, StatementRef{
+	s.addOrdered(StatementRef{
 		Type:  DEF_REGION,
 		Index: len(s.Regions) - 1,
-	})
+	}, r)
+}
+
+func (s *Scope) addJSXExpansion(e JSXExpansion) {
+	s.JSXExpansions = append(s.JSXExpansions, e)
+	s.addOrdered(StatementRef{
+		Type:  DEF_JSX_EXPANSION,
+		Index: len(s.JSXExpansions) - 1,
+	}, e.Node)
+}
+
+func (s *Scope) addMacro(m Macro) {
+	s.Macros = append(s.Macros, m)
+	s.addOrdered(StatementRef{
+		Type:  DEF_MACRO,
+		Index: len(s.Macros) - 1,
+	}, m.Node)
 }
 
 type childType = uint8
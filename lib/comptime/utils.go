@@ -1,14 +1,13 @@
 package comptime
 
 import (
-	"context"
-
 	sitter "github.com/smacker/go-tree-sitter"
 )
 
 const COMPTIME_KEYWORD = "$comptime"
+const MACRO_KEYWORD = "$macro"
 
-// node must be "array_pattern" | "identifier" | "object_pattern"
+// returns the identifiers bound by a pattern
 func getDeclaredVars(node *sitter.Node, buff []byte) []string {
 	if node == nil {
 		return nil
@@ -23,6 +22,17 @@ func getDeclaredVars(node *sitter.Node, buff []byte) []string {
 	case "pair_pattern":
 		inner := getDeclaredVars(node.ChildByFieldName("value"), buff)
 		declared = append(declared, inner...)
+	case "assignment_pattern", "object_assignment_pattern":
+		// a binding with a default value
+		inner := getDeclaredVars(node.ChildByFieldName("left"), buff)
+		declared = append(declared, inner...)
+	case "rest_pattern":
+		inner := getDeclaredVars(node.NamedChild(0), buff)
+		declared = append(declared, inner...)
+	case "required_parameter", "optional_parameter":
+		// typescript parameters
+		inner := getDeclaredVars(node.ChildByFieldName("pattern"), buff)
+		declared = append(declared, inner...)
 	case "object_pattern", "array_pattern":
 		for i := 0; i < int(node.ChildCount()); i++ {
 			inner := getDeclaredVars(node.Child(i), buff)
@@ -49,6 +59,9 @@ func parseLexicalDecl(node *sitter.Node, buff []byte) []string {
 	return declarations
 }
 
+// returns true if an identifier refers to a comptime value, defines and the
+// reflection object are comptime unless they are shadowed by a runtime
+// declaration
 func resolve(id string, scope *Scope) bool {
 	for scope != nil {
 		for _, otherId := range scope.RuntimeDeclarations {
@@ -63,13 +76,56 @@ func resolve(id string, scope *Scope) bool {
 				}
 			}
 		}
+		for _, otherId := range scope.ComptimeIdentifiers {
+			if otherId == id {
+				return true
+			}
+		}
+		for _, otherId := range scope.Defines {
+			if otherId == id {
+				return true
+			}
+		}
+		scope = scope.Parent
+	}
+	return id == REFLECTION_IDENTIFIER
+}
+
+// returns the comptime declaration an identifier refers to, nil is returned
+// if it refers to a runtime variable or is unresolved
+func ResolveDeclaration(id string, scope *Scope) *VarDeclarations {
+	for scope != nil {
+		for _, otherId := range scope.RuntimeDeclarations {
+			if otherId == id {
+				return nil
+			}
+		}
+		for i, decl := range scope.ComptimeDeclarations {
+			for _, otherId := range decl.Identifiers {
+				if otherId == id {
+					return &scope.ComptimeDeclarations[i]
+				}
+			}
+		}
 		scope = scope.Parent
 	}
-	return false
+	return nil
+}
+
+// returns the innermost scope containing the given byte offset
+func ScopeAt(root *Scope, offset uint32) *Scope {
+	for _, child := range root.Scopes {
+		if child.Node != nil &&
+			child.Node.StartByte() <= offset && offset < child.Node.EndByte() {
+			return ScopeAt(child, offset)
+		}
+	}
+	return root
 }
 
 func stringFromId(source []byte, node *sitter.Node) string {
-	if node.Type() == "identifier" {
+	// class names are type identifiers in typescript
+	if node.Type() == "identifier" || node.Type() == "type_identifier" {
 		return node.Content(source)
 	}
 	return ""
@@ -92,17 +148,19 @@ func definedIdentifiers(node *sitter.Node, source []byte) []string {
 	return nil
 }
 
+// returns the identifiers bound by the parameters of a function
 func getParameterIdentifiers(node *sitter.Node, source []byte) []string {
 	singleParam := node.ChildByFieldName("parameter")
 	if singleParam != nil {
-		return []string{singleParam.Content(source)}
-	} else {
-		params := node.ChildByFieldName("parameters")
-		var ids []string
-		for i := 0; i < int(params.NamedChildCount()); i++ {
-			ids = append(ids, getDeclaredVars(node, source)...)
-		}
-		return ids
+		return getDeclaredVars(singleParam, source)
 	}
+	params := node.ChildByFieldName("parameters")
+	if params == nil {
+		return nil
+	}
+	ids := []string{}
+	for i := 0; i < int(params.NamedChildCount()); i++ {
+		ids = append(ids, getDeclaredVars(params.NamedChild(i), source)...)
+	}
+	return ids
 }
-
@@ -0,0 +1,179 @@
+package comptime
+
+import (
+	"fmt"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+type ResolutionType = uint8
+
+const (
+	// the expression is folded or is part of comptime code
+	RESOLUTION_COMPTIME ResolutionType = iota
+	// the identifier is a parameter of a function
+	RESOLUTION_PARAMETER
+	// the identifier is declared by runtime code
+	RESOLUTION_RUNTIME_DECLARATION
+	// the identifier is not declared anywhere in the file
+	RESOLUTION_GLOBAL
+	// the expression can never be evaluated at comptime
+	RESOLUTION_NOT_FOLDABLE
+)
+
+// explains why an expression is not folded
+type Why struct {
+	// the expression enclosing the queried position
+	Expression *sitter.Node
+	// the largest comptime expression at the queried position when it is
+	// folded but the expression enclosing it is not
+	Folded *sitter.Node
+	// the runtime expressions from the enclosing expression down to the
+	// one that blocked folding
+	Path []*sitter.Node
+	// the last node of the path
+	Blocker    *sitter.Node
+	Resolution ResolutionType
+	// the scope that resolved the blocking identifier as runtime
+	Scope *Scope
+	// the comptime declaration shadowed by the runtime declaration
+	Shadowed *VarDeclarations
+}
+
+// classifies an expression without recording any regions in its scope
+func classify(node *sitter.Node, root *Scope, source []byte) childType {
+	probe := &Scope{Parent: ScopeAt(root, node.StartByte())}
+	return recurse(node, probe, source)
+}
+
+// returns true if the node is inside the body of a `$comptime:` or a
+// `$macro:` label
+func inComptimeCode(node *sitter.Node, source []byte) bool {
+	for ; node != nil; node = node.Parent() {
+		if node.Type() != "labeled_statement" {
+			continue
+		}
+		label := node.ChildByFieldName("label").Content(source)
+		if label == COMPTIME_KEYWORD || label == MACRO_KEYWORD {
+			return true
+		}
+	}
+	return false
+}
+
+func isFunction(nodeType string) bool {
+	switch nodeType {
+	case "generator_function",
+		"generator_function_declaration",
+		"function",
+		"function_declaration",
+		"arrow_function",
+		"method_definition":
+		return true
+	}
+	return false
+}
+
+// returns true if the identifier is declared in the parameters of a function
+func isParameter(function *sitter.Node, id string, source []byte) bool {
+	for _, param := range getParameterIdentifiers(function, source) {
+		if param == id {
+			return true
+		}
+	}
+	return false
+}
+
+// follows the resolution of a runtime identifier through the scopes
+func (w *Why) resolveIdentifier(node *sitter.Node, root *Scope, source []byte) {
+	id := node.Content(source)
+	for scope := ScopeAt(root, node.StartByte()); scope != nil; scope = scope.Parent {
+		for _, otherId := range scope.RuntimeDeclarations {
+			if otherId != id {
+				continue
+			}
+			w.Scope = scope
+			w.Resolution = RESOLUTION_RUNTIME_DECLARATION
+			if scope.Node != nil && isFunction(scope.Node.Type()) &&
+				isParameter(scope.Node, id, source) {
+				w.Resolution = RESOLUTION_PARAMETER
+			}
+			if scope.Parent != nil {
+				w.Shadowed = ResolveDeclaration(id, scope.Parent)
+			}
+			return
+		}
+	}
+	w.Resolution = RESOLUTION_GLOBAL
+}
+
+// explains why the expression at the given point is kept at runtime, it
+// starts from the innermost runtime expression enclosing the point and
+// follows the runtime operands down to the identifier that blocked folding
+func WhyAt(analysis *Analysis, point sitter.Point) (Why, error) {
+	root := analysis.Root
+	source := analysis.Source
+
+	node := analysis.Tree.RootNode().NamedDescendantForPointRange(point, point)
+	for node != nil && !inlineable(node.Type()) {
+		node = node.Parent()
+	}
+	if node == nil {
+		return Why{}, fmt.Errorf("no expression at %d:%d", point.Row+1, point.Column+1)
+	}
+
+	if inComptimeCode(node, source) {
+		return Why{Expression: node, Resolution: RESOLUTION_COMPTIME}, nil
+	}
+
+	// a comptime expression is folded, the query is about the runtime
+	// expression enclosing it
+	var folded *sitter.Node
+	for classify(node, root, source) == type_comptime {
+		folded = node
+		parent := node.Parent()
+		for parent != nil && (parent.Type() == "arguments" || parent.Type() == "pair") {
+			parent = parent.Parent()
+		}
+		if parent == nil || !inlineable(parent.Type()) {
+			return Why{Expression: folded, Resolution: RESOLUTION_COMPTIME}, nil
+		}
+		node = parent
+	}
+
+	w := Why{Expression: node, Folded: folded}
+	for {
+		w.Path = append(w.Path, node)
+		if classify(node, root, source) != type_runtime {
+			w.Resolution = RESOLUTION_NOT_FOLDABLE
+			break
+		}
+
+		var next *sitter.Node
+		switch node.Type() {
+		case "identifier", "shorthand_property_identifier":
+			w.resolveIdentifier(node, root, source)
+		case "generator_function", "function", "arrow_function":
+			next = node.ChildByFieldName("body")
+		case "jsx_element", "jsx_self_closing_element":
+			w.Resolution = RESOLUTION_NOT_FOLDABLE
+		default:
+			for i := 0; i < int(node.NamedChildCount()); i++ {
+				child := node.NamedChild(i)
+				if classify(child, root, source) == type_runtime {
+					next = child
+					break
+				}
+			}
+			if next == nil {
+				w.Resolution = RESOLUTION_NOT_FOLDABLE
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	w.Blocker = w.Path[len(w.Path)-1]
+	return w, nil
+}
@@ -0,0 +1,138 @@
+package esbuild
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"jscomptime/lib/comptime"
+	"jscomptime/lib/jsenv"
+	"os"
+	"path/filepath"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+const DEFAULT_FILTER = `\.[cm]?[jt]sx?$`
+
+type Options struct {
+	// a go regular expression matching the paths of the files that are
+	// compiled, defaults to DEFAULT_FILTER
+	Filter string
+	// the environment comptime code is executed in
+	Env jsenv.Env
+	// the options every file is compiled with, Dialect and Filename are set
+	// from the path of each file
+	Compile comptime.Options
+}
+
+func loaderFor(dialect comptime.Dialect) api.Loader {
+	switch dialect {
+	case comptime.DIALECT_JSX:
+		return api.LoaderJSX
+	case comptime.DIALECT_TS:
+		return api.LoaderTS
+	case comptime.DIALECT_TSX:
+		return api.LoaderTSX
+	}
+	return api.LoaderJS
+}
+
+func message(path string, source []byte, d comptime.Diagnostic) api.Message {
+	lineStart := bytes.LastIndexByte(source[:d.Start.Offset], '\n') + 1
+	lineEnd := bytes.IndexByte(source[lineStart:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(source)
+	} else {
+		lineEnd += lineStart
+	}
+
+	length := int(d.End.Offset - d.Start.Offset)
+	if d.End.Line != d.Start.Line {
+		length = lineEnd - int(d.Start.Offset)
+	}
+
+	return api.Message{
+		Text: d.Message,
+		Location: &api.Location{
+			File:     path,
+			Line:     int(d.Start.Line) + 1,
+			Column:   int(d.Start.Column),
+			Length:   length,
+			LineText: string(source[lineStart:lineEnd]),
+		},
+	}
+}
+
+// returns an esbuild plugin that compiles the comptime code of the files it
+// loads
+func Plugin(opts Options) api.Plugin {
+	filter := opts.Filter
+	if filter == "" {
+		filter = DEFAULT_FILTER
+	}
+	env := opts.Env
+	if env == nil {
+		env = jsenv.Nodejs{Command: "node"}
+	}
+
+	return api.Plugin{
+		Name: "jscomptime",
+		Setup: func(build api.PluginBuild) {
+			build.OnLoad(
+				api.OnLoadOptions{Filter: filter, Namespace: "file"},
+				func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					source, err := os.ReadFile(args.Path)
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+					// leave files without comptime code to esbuild, defines
+					// can be referenced from any file
+					if len(opts.Compile.Defines) == 0 &&
+						!bytes.Contains(source, []byte(comptime.COMPTIME_KEYWORD)) &&
+						!bytes.Contains(source, []byte(comptime.MACRO_KEYWORD)) {
+						return api.OnLoadResult{}, nil
+					}
+
+					compileOpts := opts.Compile
+					compileOpts.Dialect = comptime.DialectFromPath(args.Path)
+					compileOpts.Filename = args.Path
+
+					result, err := comptime.Compile(
+						context.Background(),
+						source,
+						env,
+						compileOpts,
+					)
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+
+					loadResult := api.OnLoadResult{
+						Loader:     loaderFor(compileOpts.Dialect),
+						ResolveDir: filepath.Dir(args.Path),
+						WatchFiles: result.Dependencies,
+					}
+					for _, d := range result.Diagnostics {
+						m := message(args.Path, source, d)
+						if d.Severity == comptime.SEVERITY_ERROR {
+							loadResult.Errors = append(loadResult.Errors, m)
+						} else {
+							loadResult.Warnings = append(loadResult.Warnings, m)
+						}
+					}
+					if comptime.HasErrors(result.Diagnostics) {
+						return loadResult, nil
+					}
+
+					// esbuild picks up inline source maps of loaded files
+					contents := result.Code +
+						"\n//# sourceMappingURL=data:application/json;base64," +
+						base64.StdEncoding.EncodeToString(result.SourceMap) +
+						"\n"
+					loadResult.Contents = &contents
+					return loadResult, nil
+				},
+			)
+		},
+	}
+}
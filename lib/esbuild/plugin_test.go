@@ -0,0 +1,97 @@
+package esbuild
+
+import (
+	"jscomptime/lib/comptime"
+	"jscomptime/lib/jsenv"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// writes the files in a temporary directory, which becomes the working
+// directory, and bundles the first one with the plugin, the test is skipped
+// if node is not installed
+func build(t *testing.T, files map[string]string, entry string, opts Options) api.BuildResult {
+	t.Helper()
+	_, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts.Env = jsenv.Nodejs{Command: "node", Output: &strings.Builder{}}
+	return api.Build(api.BuildOptions{
+		EntryPoints: []string{filepath.Join(dir, entry)},
+		Bundle:      true,
+		Write:       false,
+		Outdir:      filepath.Join(dir, "out"),
+		LogLevel:    api.LogLevelSilent,
+		Plugins:     []api.Plugin{Plugin(opts)},
+	})
+}
+
+func TestPlugin(t *testing.T) {
+	result := build(t, map[string]string{
+		"main.ts": "import { double } from './lib.js'\n" +
+			"$comptime: const n: number = 21\n" +
+			"console.log(double(n * 2))\n",
+		"lib.js": "export function double(x) { return x * 2 }\n",
+	}, "main.ts", Options{})
+	if len(result.Errors) != 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+	if len(result.OutputFiles) != 1 {
+		t.Fatalf("expected one output file, got %d", len(result.OutputFiles))
+	}
+	code := string(result.OutputFiles[0].Contents)
+	if !strings.Contains(code, "double(42)") {
+		t.Errorf("the comptime region is not folded:\n%s", code)
+	}
+}
+
+func TestPluginDefines(t *testing.T) {
+	result := build(t, map[string]string{
+		"main.js": "console.log(MODE + '!')\n",
+	}, "main.js", Options{Compile: comptime.Options{Defines: map[string]string{"MODE": `"production"`}}})
+	if len(result.Errors) != 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+	if code := string(result.OutputFiles[0].Contents); !strings.Contains(code, `"production!"`) {
+		t.Errorf("the define is not folded:\n%s", code)
+	}
+}
+
+func TestPluginDiagnostics(t *testing.T) {
+	result := build(t, map[string]string{
+		"main.js": "$comptime: var p = 1\nf({ p }); $comptime: const x = x + 1\n",
+	}, "main.js", Options{})
+	if len(result.Errors) != 1 {
+		t.Fatalf("expected one error, got %v", result.Errors)
+	}
+	location := result.Errors[0].Location
+	if location == nil || location.Line != 2 || location.Column != 21 || location.Length != len("const x = x + 1") {
+		t.Errorf("unexpected location: %+v", location)
+	}
+	if location != nil && location.LineText != "f({ p }); $comptime: const x = x + 1" {
+		t.Errorf("unexpected line: %q", location.LineText)
+	}
+}
@@ -0,0 +1,450 @@
+// sets up the parts of the harness that are shared by every program executed
+// in the process, programs use it through nodejs-exporter.js
+if (globalThis.__jscomptime_runtime === undefined) {
+    const fs = require("node:fs")
+    const path = require("node:path")
+    const url = require("node:url")
+
+    const runtime = {
+        // the file descriptor of the pipe messages are written to
+        fd: parseInt(process.env.JSCOMPTIME_FD),
+        // records the dependencies of the evaluation currently running
+        track: () => { },
+        // closes the evaluation currently running if it didn't finish
+        abort: () => { },
+        // settles once the evaluation currently running is done
+        completion: undefined,
+        // returns the binding of a module imported by the output of the
+        // evaluation currently running
+        importBinding: () => {
+            throw new Error("modules can only be imported while comptime code is running")
+        },
+    }
+
+    function wrap(object, name) {
+        const original = object[name]
+        object[name] = function(file, ...args) {
+            runtime.track(file)
+            return original.call(this, file, ...args)
+        }
+    }
+    // files read by comptime code are reported as dependencies
+    for (const name of [
+        "readFileSync", "readFile", "existsSync", "statSync",
+        "readdirSync", "readdir", "openSync", "open",
+    ]) {
+        wrap(fs, name)
+    }
+    for (const name of ["readFile", "readdir", "stat", "open"]) {
+        wrap(fs.promises, name)
+    }
+
+    // typed arrays are rebuilt with the builtin constructor they extend, so
+    // that a node Buffer becomes a Uint8Array
+    const typedArrays = [
+        Int8Array, Uint8Array, Uint8ClampedArray, Int16Array, Uint16Array,
+        Int32Array, Uint32Array, Float32Array, Float64Array,
+        BigInt64Array, BigUint64Array,
+    ]
+
+    // well-known symbols are referred to by name
+    const wellKnownSymbols = new Map(
+        Object.getOwnPropertyNames(Symbol)
+            .filter((name) => typeof Symbol[name] === "symbol")
+            .map((name) => [Symbol[name], `Symbol.${name}`])
+    )
+
+    // values that can't be rebuilt from their contents
+    const opaqueTypes = [
+        ["a WeakMap", WeakMap], ["a WeakSet", WeakSet], ["a WeakRef", WeakRef],
+        ["a Promise", Promise], ["an Error", Error],
+    ]
+
+    function bytes(buffer, path) {
+        const items = [...new Uint8Array(buffer)].join(", ")
+        return `new Uint8Array([${items}]).buffer`
+    }
+
+    // a module imported by the output, it is inlined as the binding of an
+    // import statement inserted at the top of the output
+    class ModuleImport {
+        constructor(specifier, name) {
+            this.specifier = specifier
+            // the name of the export, "*" for the module namespace
+            this.name = name
+        }
+    }
+
+    function isThenable(value) {
+        return (isObject(value) || typeof value === "function") && typeof value.then === "function"
+    }
+
+    function isObject(value) {
+        return value !== null && typeof value === "object"
+    }
+
+    // returns true for objects whose contents are other values, the others
+    // are rebuilt from data only
+    function isContainer(value) {
+        return Array.isArray(value) || value instanceof Map || value instanceof Set ||
+            !(value instanceof Date || value instanceof RegExp || value instanceof ArrayBuffer ||
+                value instanceof ModuleImport ||
+                ArrayBuffer.isView(value) || opaqueTypes.some(([, type]) => value instanceof type))
+    }
+
+    // returns the values directly contained in an object along with the
+    // path they are found at
+    function contents(value, path) {
+        if (Array.isArray(value)) {
+            return Array.from(value, (v, i) => [v, `${path}[${i}]`])
+        }
+        if (value instanceof Map) {
+            return [...value].flatMap(([k, v], i) => [
+                [k, `[...${path}.keys()][${i}]`],
+                [v, `[...${path}.values()][${i}]`],
+            ])
+        }
+        if (value instanceof Set) {
+            return [...value].map((v, i) => [v, `[...${path}][${i}]`])
+        }
+        if (!isContainer(value)) {
+            return []
+        }
+        return Object.entries(value).map(([k, v]) => [v, `${path}[${JSON.stringify(k)}]`])
+    }
+
+    // returns the objects that are reachable more than once from the value,
+    // either because they are shared or because they are part of a cycle,
+    // along with the path they are first found at
+    function sharedObjects(value) {
+        const seen = new Map()
+        const shared = new Map()
+        const visit = (v, path) => {
+            if (!isObject(v)) {
+                return
+            }
+            if (seen.has(v)) {
+                shared.set(v, seen.get(v))
+                return
+            }
+            seen.set(v, path)
+            for (const [child, childPath] of contents(v, path)) {
+                visit(child, childPath)
+            }
+        }
+        visit(value, "value")
+        return shared
+    }
+
+    // serializes values, the objects in names are referred to by the name of
+    // the variable they are stored in
+    class Serializer {
+        constructor(names) {
+            this.names = names
+        }
+
+        // returns an expression that evaluates to the value, path is the
+        // expression the value was found at and is only used in errors
+        value(value, path) {
+            const fail = (what, hint) => {
+                throw new Error(`cannot serialize ${what} at ${path}` + (hint ? `, ${hint}` : ""))
+            }
+            switch (typeof value) {
+                case "undefined":
+                    return "undefined"
+                case "boolean":
+                    return value.toString()
+                case "number":
+                    // toString writes NaN, Infinity and -Infinity but not -0
+                    return Object.is(value, -0) ? "-0" : value.toString()
+                case "string":
+                    // JSON.stringify is used to escape the string.
+                    return JSON.stringify(value)
+                case "bigint":
+                    return `${value}n`
+                case "symbol": {
+                    const key = Symbol.keyFor(value)
+                    if (key !== undefined) {
+                        return `Symbol.for(${JSON.stringify(key)})`
+                    }
+                    if (wellKnownSymbols.has(value)) {
+                        return wellKnownSymbols.get(value)
+                    }
+                    return fail(value.toString(), "only symbols from Symbol.for and well-known symbols can be inlined")
+                }
+                case "function":
+                    return fail(`the function ${value.name || "(anonymous)"}`)
+            }
+
+            if (value === null) {
+                return "null"
+            }
+            if (this.names.has(value)) {
+                return this.names.get(value)
+            }
+            if (value instanceof ModuleImport) {
+                return runtime.importBinding(value)
+            }
+            if (value instanceof Date) {
+                return `new Date(${value.getTime()})`
+            }
+            if (value instanceof RegExp) {
+                return value.toString()
+            }
+            if (value instanceof Map) {
+                const entries = this.entries(value, path).map(([k, v]) => `[${k}, ${v}]`)
+                return entries.length === 0 ? "new Map()" : `new Map([${entries.join(", ")}])`
+            }
+            if (value instanceof Set) {
+                const items = this.items(value, path)
+                return items.length === 0 ? "new Set()" : `new Set([${items.join(", ")}])`
+            }
+            if (value instanceof DataView) {
+                const buffer = value.buffer.slice(value.byteOffset, value.byteOffset + value.byteLength)
+                return `new DataView(${bytes(buffer)})`
+            }
+            if (ArrayBuffer.isView(value)) {
+                const constructor = typedArrays.find((c) => value instanceof c)
+                const items = Array.from(value, (v, i) => this.value(v, `${path}[${i}]`))
+                return `new ${constructor.name}([${items.join(", ")}])`
+            }
+            if (value instanceof ArrayBuffer) {
+                return bytes(value)
+            }
+            for (const [what, type] of opaqueTypes) {
+                if (value instanceof type) {
+                    return fail(what)
+                }
+            }
+            return this.literal(value, path)
+        }
+
+        // fails for instances of classes other than Object and Array, they
+        // would lose their prototype once inlined as literals, objects
+        // without a prototype are created with Object.create(null)
+        checkPrototype(value, path) {
+            const prototype = Object.getPrototypeOf(value)
+            const expected = Array.isArray(value) ? Array.prototype : Object.prototype
+            if (prototype === expected || (prototype === null && !Array.isArray(value))) {
+                return
+            }
+            const name = prototype === null ? "an array without a prototype" :
+                `an instance of ${prototype.constructor?.name || "(anonymous)"}`
+            throw new Error(`cannot serialize ${name} at ${path}, only plain objects and arrays are inlined as literals`)
+        }
+
+        // returns an array or object literal
+        literal(value, path) {
+            this.checkPrototype(value, path)
+            if (Array.isArray(value)) {
+                const items = value.map((v, i) => this.value(v, `${path}[${i}]`))
+                return `[${items.join(", ")}]`
+            }
+            if (Object.getPrototypeOf(value) === null) {
+                const properties = this.properties(value, path)
+                return properties === "{}" ? "Object.create(null)" : `Object.assign(Object.create(null), ${properties})`
+            }
+            return this.properties(value, path)
+        }
+
+        // returns an object literal with the properties of an object
+        properties(value, path) {
+            const entries = Object.entries(value).map(([k, v]) => {
+                const key = JSON.stringify(k)
+                const serialized = this.value(v, `${path}[${key}]`)
+                // a __proto__ key would set the prototype of the object
+                return k === "__proto__" ? `[${key}]: ${serialized}` : `${key}: ${serialized}`
+            })
+            return `{${entries.join(", ")}}`
+        }
+
+        entries(map, path) {
+            return [...map].map(([k, v], i) => {
+                const key = this.value(k, `[...${path}.keys()][${i}]`)
+                return [key, this.value(v, `[...${path}.values()][${i}]`)]
+            })
+        }
+
+        items(set, path) {
+            return [...set].map((v, i) => this.value(v, `[...${path}][${i}]`))
+        }
+    }
+
+    // returns an expression that evaluates to the value, objects that are
+    // reachable more than once are created first and filled in afterwards so
+    // that their identity is preserved
+    function serializeValue(value) {
+        const shared = sharedObjects(value)
+        const names = new Map()
+        const serializer = new Serializer(names)
+        if (shared.size === 0) {
+            return serializer.value(value, "value")
+        }
+
+        const declarations = []
+        for (const [object, path] of shared) {
+            const name = `$${names.size}`
+            if (Array.isArray(object)) {
+                serializer.checkPrototype(object, path)
+                declarations.push(`${name} = []`)
+            } else if (object instanceof Map) {
+                declarations.push(`${name} = new Map()`)
+            } else if (object instanceof Set) {
+                declarations.push(`${name} = new Set()`)
+            } else if (isContainer(object)) {
+                serializer.checkPrototype(object, path)
+                declarations.push(`${name} = ${Object.getPrototypeOf(object) === null ? "Object.create(null)" : "{}"}`)
+            } else {
+                declarations.push(`${name} = ${serializer.value(object, path)}`)
+            }
+            names.set(object, name)
+        }
+
+        // objects are filled in once all of them are created
+        const statements = [`const ${declarations.join(", ")}`]
+        for (const [object, path] of shared) {
+            const name = names.get(object)
+            if (object instanceof Map) {
+                const entries = serializer.entries(object, path)
+                if (entries.length > 0) {
+                    statements.push(name + entries.map(([k, v]) => `.set(${k}, ${v})`).join(""))
+                }
+            } else if (object instanceof Set) {
+                const items = serializer.items(object, path)
+                if (items.length > 0) {
+                    statements.push(name + items.map((v) => `.add(${v})`).join(""))
+                }
+            } else if (isContainer(object) && contents(object, path).length > 0) {
+                const filled = Array.isArray(object) ? serializer.literal(object, path) : serializer.properties(object, path)
+                statements.push(`Object.assign(${name}, ${filled})`)
+            }
+        }
+        statements.push(`return ${serializer.value(value, "value")}`)
+        return `(() => { ${statements.join("; ")} })()`
+    }
+
+    // starts an evaluation, the returned functions send messages to the pipe
+    // the evaluation is listened on, one json line per message
+    runtime.start = function(harness) {
+        const fd = runtime.fd
+        const modules = new Set(Object.keys(require.cache))
+
+        // nothing is sent once the evaluation is done so that late messages
+        // are not mixed with the ones of the next evaluation
+        let open = true
+        function send(key, text) {
+            if (!open) {
+                return
+            }
+            const line = Buffer.from(JSON.stringify({ key: String(key), content: text }) + "\n")
+            let written = 0
+            while (written < line.length) {
+                written += fs.writeSync(fd, line, written)
+            }
+        }
+
+        const dependencies = new Set()
+        function track(file) {
+            if (file instanceof URL) {
+                file = url.fileURLToPath(file)
+            }
+            if (typeof file !== "string") {
+                return
+            }
+            const resolved = path.resolve(file)
+            if (dependencies.has(resolved)) {
+                return
+            }
+            dependencies.add(resolved)
+            send("dep", resolved)
+        }
+        runtime.track = track
+
+        // identical imports share the same binding
+        const imports = new Map()
+        const importBinding = runtime.importBinding
+        runtime.importBinding = (module) => {
+            const key = JSON.stringify([module.specifier, module.name])
+            if (!imports.has(key)) {
+                const binding = `__ct_import_${imports.size}`
+                imports.set(key, binding)
+                send("import", JSON.stringify({ binding, specifier: module.specifier, name: module.name }))
+            }
+            return imports.get(key)
+        }
+
+        function close() {
+            runtime.track = () => { }
+            runtime.abort = () => { }
+            runtime.importBinding = importBinding
+        }
+        runtime.abort = () => {
+            close()
+            open = false
+        }
+
+        function done() {
+            // modules loaded with require are dependencies as well
+            for (const file of Object.keys(require.cache)) {
+                if (file !== harness && !modules.has(file)) {
+                    track(file)
+                }
+            }
+            close()
+            send("done", "")
+            open = false
+        }
+
+        function fail(err) {
+            close()
+            send("error", err instanceof Error ? err.stack : String(err))
+            open = false
+        }
+
+        return {
+            // thenables are awaited before their value is serialized, they
+            // are exported as a resolved promise unless settled is set
+            exportValue(id, value, settled = false) {
+                if (isThenable(value)) {
+                    return Promise.resolve(value).then((v) => {
+                        const serialized = serializeValue(v)
+                        send(id, settled ? serialized : `Promise.resolve(${serialized})`)
+                    })
+                }
+                send(id, serializeValue(value))
+            },
+            // exports the value of a comptime declaration, declarations are
+            // not inlined so values that can't be serialized are skipped
+            exportBinding(id, value) {
+                const exportResolved = (v, wrap = (serialized) => serialized) => {
+                    let serialized
+                    try {
+                        serialized = serializeValue(v)
+                    } catch {
+                        return
+                    }
+                    send(id, wrap(serialized))
+                }
+                if (isThenable(value)) {
+                    return Promise.resolve(value).then(
+                        (v) => exportResolved(v, (serialized) => `Promise.resolve(${serialized})`),
+                        () => { },
+                    )
+                }
+                exportResolved(value)
+            },
+            // returns a value inlined as an export of a module imported by
+            // the output
+            importModule(specifier, name = "*") {
+                return new ModuleImport(specifier, name)
+            },
+            // runs the program, it is an async function so that comptime
+            // code can use await at its top level
+            run(program) {
+                runtime.completion = program().then(done, fail)
+            },
+        }
+    }
+
+    globalThis.__jscomptime_runtime = runtime
+}
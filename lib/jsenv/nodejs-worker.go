@@ -0,0 +1,213 @@
+package jsenv
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+//go:embed nodejs-worker.js
+var worker string
+
+// an environment that keeps a single node process running between
+// evaluations, evaluations are executed one at a time
+type NodejsWorker struct {
+	Command string
+	// where the output of comptime code is written, defaults to os.Stderr
+	// so that it is not mixed with the compiled code
+	Output io.Writer
+
+	lock      sync.Mutex
+	cmd       *exec.Cmd
+	requests  io.WriteCloser
+	responses *bufio.Scanner
+	messages  *listener
+	exited    chan struct{}
+	script    string
+}
+
+type workerRequest struct {
+	File string `json:"file"`
+}
+
+type workerResponse struct {
+	Error string `json:"error"`
+}
+
+// starts the node process if it isn't running
+func (w *NodejsWorker) start() error {
+	if w.cmd != nil {
+		select {
+		case <-w.exited:
+			w.stop()
+		default:
+			return nil
+		}
+	}
+
+	err := os.Mkdir(".jscomptime", 0777)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+	script, err := os.CreateTemp(".jscomptime", "worker-*.js")
+	if err != nil {
+		return err
+	}
+	_, err = script.WriteString(runtime + worker)
+	script.Close()
+	if err != nil {
+		os.Remove(script.Name())
+		return err
+	}
+
+	requestsRead, requestsWrite, err := os.Pipe()
+	if err != nil {
+		os.Remove(script.Name())
+		return err
+	}
+	responsesRead, responsesWrite, err := os.Pipe()
+	if err != nil {
+		requestsRead.Close()
+		requestsWrite.Close()
+		os.Remove(script.Name())
+		return err
+	}
+	messagesRead, messagesWrite, err := os.Pipe()
+	if err != nil {
+		requestsRead.Close()
+		requestsWrite.Close()
+		responsesRead.Close()
+		responsesWrite.Close()
+		os.Remove(script.Name())
+		return err
+	}
+
+	output := w.Output
+	if output == nil {
+		output = os.Stderr
+	}
+	cmd := exec.Command(w.Command, script.Name())
+	cmd.Stdout = output
+	cmd.Stderr = os.Stderr
+	// fd 3, fd 4 and fd 5 in the child process
+	cmd.ExtraFiles = []*os.File{requestsRead, responsesWrite, messagesWrite}
+	err = cmd.Start()
+	requestsRead.Close()
+	responsesWrite.Close()
+	messagesWrite.Close()
+	if err != nil {
+		requestsWrite.Close()
+		responsesRead.Close()
+		messagesRead.Close()
+		os.Remove(script.Name())
+		return err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		responsesRead.Close()
+		close(exited)
+	}()
+
+	w.cmd = cmd
+	w.requests = requestsWrite
+	w.responses = bufio.NewScanner(responsesRead)
+	w.responses.Buffer(make([]byte, 0, 4096), 16*1024*1024)
+	w.messages = listen(messagesRead)
+	w.exited = exited
+	w.script = script.Name()
+	return nil
+}
+
+func (w *NodejsWorker) stop() {
+	if w.cmd == nil {
+		return
+	}
+	w.requests.Close()
+	select {
+	case <-w.exited:
+	case <-time.After(time.Second):
+		// something scheduled by comptime code is keeping it alive
+		w.cmd.Process.Kill()
+		<-w.exited
+	}
+	w.messages.Close()
+	os.Remove(w.script)
+	w.cmd = nil
+}
+
+func (w *NodejsWorker) Eval(ctx context.Context, program Program, results []EvalResult) (Evaluation, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	err := w.start()
+	if err != nil {
+		return Evaluation{}, err
+	}
+
+	file, err := writeProgram(program, "")
+	if err != nil {
+		return Evaluation{}, err
+	}
+	defer os.Remove(file)
+
+	request, err := json.Marshal(workerRequest{File: file})
+	if err != nil {
+		return Evaluation{}, err
+	}
+	_, err = w.requests.Write(append(request, '\n'))
+	if err != nil {
+		return Evaluation{}, err
+	}
+
+	failed := make(chan error, 1)
+	received := make(chan struct{})
+	go func() {
+		defer close(received)
+		if !w.responses.Scan() {
+			failed <- errors.New("comptime worker exited")
+			return
+		}
+		response := workerResponse{}
+		err := json.Unmarshal(w.responses.Bytes(), &response)
+		if err != nil {
+			failed <- err
+			return
+		}
+		if response.Error != "" {
+			failed <- errors.New(response.Error)
+		}
+	}()
+
+	evaluation, err := w.messages.collect(ctx, results, failed)
+	if err != nil {
+		// the worker can't be reused while it is still executing the
+		// program
+		select {
+		case <-received:
+		default:
+			w.cmd.Process.Kill()
+			w.stop()
+		}
+		return Evaluation{}, err
+	}
+	// the response is written once the program is done
+	<-received
+	return evaluation, nil
+}
+
+// stops the node process
+func (w *NodejsWorker) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.stop()
+	return nil
+}
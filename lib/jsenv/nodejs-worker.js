@@ -0,0 +1,37 @@
+// evaluates programs sent by the go side, requests are read from fd 3 and
+// responses are written to fd 4 as json lines, the messages of the programs
+// are written to fd 5
+const fs = require("node:fs")
+const readline = require("node:readline")
+
+const lines = readline.createInterface({
+    input: fs.createReadStream(null, { fd: 3 }),
+})
+
+globalThis.__jscomptime_runtime.fd = 5
+
+lines.on("line", async (line) => {
+    const request = JSON.parse(line)
+    const runtime = globalThis.__jscomptime_runtime
+
+    // modules outside of node_modules are reloaded so that changes to them
+    // are picked up
+    for (const file of Object.keys(require.cache)) {
+        if (!file.includes("node_modules") && file !== __filename) {
+            delete require.cache[file]
+        }
+    }
+
+    let response = {}
+    try {
+        runtime.completion = undefined
+        require(request.file)
+        // errors of the program itself are sent with its results
+        await runtime.completion
+    } catch (err) {
+        response = { error: err instanceof Error ? err.stack : String(err) }
+        runtime.abort()
+    }
+    delete require.cache[request.file]
+    fs.writeSync(4, JSON.stringify(response) + "\n")
+})
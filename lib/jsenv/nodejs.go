@@ -1,134 +1,227 @@
 package jsenv
 
 import (
-	"bytes"
 	"context"
 	_ "embed"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"net"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
-	"strings"
-	"time"
 )
 
+//go:embed nodejs-runtime.js
+var runtime string
+
 //go:embed nodejs-exporter.js
 var exporter string
 
 type Nodejs struct {
 	Command string
+	// where the output of comptime code is written, defaults to os.Stderr
+	// so that it is not mixed with the compiled code
+	Output io.Writer
 }
 
-func (env Nodejs) Eval(ctx context.Context, code string, results []EvalResult) error {
-	conn, err := net.ListenPacket("udp", ":0")
+// makes require, __filename and __dirname behave as if the code was
+// executed from the source file
+func modulePrelude(filename string) (string, error) {
+	abs, err := filepath.Abs(filename)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer conn.Close()
-	_, port, err := net.SplitHostPort(conn.LocalAddr().String())
+	quoted, err := json.Marshal(abs)
 	if err != nil {
-		return err
+		return "", err
+	}
+	return fmt.Sprintf(
+		"require = require(\"node:module\").createRequire(%s)\n"+
+			"__filename = %s\n"+
+			"__dirname = require(\"node:path\").dirname(__filename)\n",
+		quoted, quoted,
+	), nil
+}
+
+// writes the code executed for a program to a new file in .jscomptime, the
+// caller is responsible for removing it
+func writeProgram(program Program, header string) (string, error) {
+	prelude := ""
+	if program.Filename != "" {
+		var err error
+		prelude, err = modulePrelude(program.Filename)
+		if err != nil {
+			return "", err
+		}
 	}
-	executedCode := exporter + code
+	executedCode := header + exporter + prelude +
+		"__jscomptime_run(async () => {\n" + program.Code + "\n})\n"
 
-	err = os.Mkdir(".jscomptime", 0777)
+	err := os.Mkdir(".jscomptime", 0777)
 	if err != nil && !os.IsExist(err) {
-		return err
+		return "", err
+	}
+	// each evaluation gets its own file as several can run at once
+	file, err := os.CreateTemp(".jscomptime", "code-*.js")
+	if err != nil {
+		return "", err
 	}
-	err = os.WriteFile(".jscomptime/code.js", []byte(executedCode), 0777)
+	_, err = file.WriteString(executedCode)
 	if err != nil {
-		return err
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
 	}
+	err = file.Close()
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	abs, err := filepath.Abs(file.Name())
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return abs, nil
+}
 
-	errorc := make(chan error)
-	outputc := make(chan eval)
+// a message sent by a program executed by node, messages are written to a
+// pipe as json lines so that results of any size arrive whole and in order
+type message struct {
+	Key     string `json:"key"`
+	Content string `json:"content"`
+}
 
-	listenCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	go listenEval(listenCtx, conn, outputc, errorc)
+// receives the messages of the programs executed by a node process
+type listener struct {
+	messages chan message
+	// receives an error once the pipe can't be read anymore
+	errorc chan error
+	closed chan struct{}
+}
 
+// reads the messages written to a pipe until it is closed
+func listen(pipe io.ReadCloser) *listener {
+	l := &listener{
+		messages: make(chan message),
+		errorc:   make(chan error, 1),
+		closed:   make(chan struct{}),
+	}
 	go func() {
-		cmd := exec.Command(env.Command, ".jscomptime/code.js")
-		cmd.Env = append(cmd.Env, "JSCOMPTIME_PORT="+port)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-
-		fmt.Println("-------- nodejs comptime code output --------")
-		err := cmd.Run()
-		if err != nil {
-			errorc <- err
-		}
-	}()
-
-	evaluated := 0
-	for evaluated < len(results) {
-		select {
-		case err := <-errorc:
+		defer pipe.Close()
+		decoder := json.NewDecoder(pipe)
+		for {
+			m := message{}
+			err := decoder.Decode(&m)
+			if errors.Is(err, io.EOF) {
+				err = errors.New("comptime code exited before it finished")
+			}
 			if err != nil {
-				return err
+				l.errorc <- err
+				return
+			}
+			select {
+			case l.messages <- m:
+			case <-l.closed:
+				return
 			}
-		case e := <-outputc:
-			results[e.id].Result = e.content
-			evaluated++
 		}
-	}
-
-	return nil
+	}()
+	return l
 }
 
-type eval struct {
-	id      int
-	content string
+// stops delivering messages, the pipe is closed once its writer is done
+func (l *listener) Close() {
+	close(l.closed)
 }
 
-func listenEval(
+// collects the messages of a program until it is done, failed receives an
+// error if the program could not be executed
+func (l *listener) collect(
 	ctx context.Context,
-	conn net.PacketConn,
-	output chan eval,
-	error chan error,
-) {
-	current := bytes.NewBuffer(nil)
-	chunk := make([]byte, 512, 512)
+	results []EvalResult,
+	failed chan error,
+) (Evaluation, error) {
+	evaluation := Evaluation{}
 	for {
 		select {
 		case <-ctx.Done():
-			return
-		default:
-			break
+			return Evaluation{}, ctx.Err()
+		case err := <-l.errorc:
+			return Evaluation{}, err
+		case err := <-failed:
+			return Evaluation{}, err
+		case m := <-l.messages:
+			switch m.Key {
+			case "dep":
+				evaluation.Dependencies = append(evaluation.Dependencies, m.Content)
+			case "import":
+				var imported Import
+				err := json.Unmarshal([]byte(m.Content), &imported)
+				if err != nil {
+					return Evaluation{}, err
+				}
+				evaluation.Imports = append(evaluation.Imports, imported)
+			case "done":
+				return evaluation, nil
+			case "error":
+				return Evaluation{}, errors.New(m.Content)
+			default:
+				id, err := strconv.Atoi(m.Key)
+				if err != nil {
+					return Evaluation{}, err
+				}
+				if id < 0 || id >= len(results) {
+					return Evaluation{}, fmt.Errorf("comptime code sent an unknown result %d", id)
+				}
+				results[id].Result = m.Content
+			}
 		}
+	}
+}
 
-		err := conn.SetReadDeadline(time.Now().Add(time.Second))
-		if err != nil {
-			error <- err
-			return
-		}
-		n, _, err := conn.ReadFrom(chunk)
-		if err != nil {
-			error <- err
-			return
-		}
-		_, err = current.Write(chunk[:n])
-		if err != nil {
-			error <- err
-			return
-		}
+func (env Nodejs) Eval(ctx context.Context, program Program, results []EvalResult) (Evaluation, error) {
+	file, err := writeProgram(program, runtime)
+	if err != nil {
+		return Evaluation{}, err
+	}
+	defer os.Remove(file)
 
-		// is null-terminated
-		if n > 0 && string(chunk)[n-1] == 0 {
-			text := current.String()
-			split := strings.SplitN(text[:len(text)-1], "|", 2)
-			id, err := strconv.Atoi(split[0])
-			if err != nil {
-				error <- err
-				return
-			}
+	output := env.Output
+	if output == nil {
+		output = os.Stderr
+	}
 
-			output <- eval{
-				id:      id,
-				content: split[1],
-			}
-			current.Reset()
-		}
+	messagesRead, messagesWrite, err := os.Pipe()
+	if err != nil {
+		return Evaluation{}, err
+	}
+	cmdCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(cmdCtx, env.Command, file)
+	cmd.Env = append(os.Environ(), "JSCOMPTIME_FD=3")
+	cmd.Stdout = output
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{messagesWrite}
+	err = cmd.Start()
+	// the pipe is closed once the child process exits
+	messagesWrite.Close()
+	if err != nil {
+		messagesRead.Close()
+		return Evaluation{}, err
 	}
+	l := listen(messagesRead)
+	defer l.Close()
+
+	failed := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		if err != nil {
+			failed <- err
+		}
+	}()
+
+	return l.collect(ctx, results, failed)
 }
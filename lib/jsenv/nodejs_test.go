@@ -0,0 +1,149 @@
+package jsenv
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// returns the environments backed by node, they write their files in a
+// temporary directory, the test is skipped if node is not installed
+func nodeEnvs(t *testing.T) map[string]Env {
+	t.Helper()
+	_, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	worker := &NodejsWorker{Command: "node", Output: &strings.Builder{}}
+	t.Cleanup(func() { worker.Close() })
+	return map[string]Env{
+		"nodejs": Nodejs{Command: "node", Output: &strings.Builder{}},
+		"worker": worker,
+	}
+}
+
+func TestEvalLargeResults(t *testing.T) {
+	for name, env := range nodeEnvs(t) {
+		t.Run(name, func(t *testing.T) {
+			// characters outside of the basic plane are made of two utf-16
+			// code units
+			code := `await __jscomptime_export_value(0, "😀".repeat(300000))
+await __jscomptime_export_value(1, "a".repeat(1000000))`
+			results := make([]EvalResult, 2)
+			_, err := env.Eval(context.Background(), Program{Code: code}, results)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{
+				`"` + strings.Repeat("😀", 300000) + `"`,
+				`"` + strings.Repeat("a", 1000000) + `"`,
+			}
+			for i := range want {
+				if results[i].Result != want[i] {
+					t.Errorf("result %d: got %d bytes, want %d", i, len(results[i].Result), len(want[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	env := nodeEnvs(t)["worker"]
+	tests := []struct {
+		name  string
+		value string
+		want  string
+		err   string
+	}{
+		{name: "map", value: `new Map([["a", 1], [2, { b: true }]])`, want: `new Map([["a", 1], [2, {"b": true}]])`},
+		{name: "set", value: `new Set([1, "x"])`, want: `new Set([1, "x"])`},
+		{name: "date", value: `new Date(0)`, want: `new Date(0)`},
+		{name: "regexp", value: `/a+b/gi`, want: `/a+b/gi`},
+		{name: "typed array", value: `new Uint16Array([1, 2])`, want: `new Uint16Array([1, 2])`},
+		{name: "buffer", value: `Buffer.from([1, 2])`, want: `new Uint8Array([1, 2])`},
+		{name: "bigint", value: `10n`, want: `10n`},
+		{name: "registered symbol", value: `Symbol.for("k")`, want: `Symbol.for("k")`},
+		{name: "well-known symbol", value: `Symbol.iterator`, want: `Symbol.iterator`},
+		{name: "__proto__ key", value: `JSON.parse('{"__proto__": 1}')`, want: `{["__proto__"]: 1}`},
+		{
+			name:  "cycle",
+			value: `(() => { const o = { a: 1 }; o.self = o; return o })()`,
+			want:  `(() => { const $0 = {}; Object.assign($0, {"a": 1, "self": $0}); return $0 })()`,
+		},
+		{
+			name:  "shared",
+			value: `(() => { const s = [1]; return { a: s, b: s } })()`,
+			want:  `(() => { const $0 = []; Object.assign($0, [1]); return {"a": $0, "b": $0} })()`,
+		},
+		{name: "null prototype", value: `Object.assign(Object.create(null), { a: 1 })`, want: `Object.assign(Object.create(null), {"a": 1})`},
+		{name: "empty null prototype", value: `Object.create(null)`, want: `Object.create(null)`},
+		{
+			name:  "shared null prototype",
+			value: `(() => { const o = Object.create(null); return [o, o] })()`,
+			want:  `(() => { const $0 = Object.create(null); return [$0, $0] })()`,
+		},
+		{name: "function", value: `[function handler() {}]`, err: "cannot serialize the function handler at value[0]"},
+		{name: "unregistered symbol", value: `Symbol("s")`, err: "cannot serialize Symbol(s) at value"},
+		{name: "weak map", value: `{ m: new WeakMap() }`, err: `cannot serialize a WeakMap at value["m"]`},
+		{name: "class instance", value: `new (class Point {})()`, err: "cannot serialize an instance of Point at value"},
+		{
+			name:  "array without a prototype",
+			value: `Object.setPrototypeOf([], null)`,
+			err:   "cannot serialize an array without a prototype at value",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code := "await __jscomptime_export_value(0, " + test.value + ")"
+			results := make([]EvalResult, 1)
+			_, err := env.Eval(context.Background(), Program{Code: code}, results)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if results[0].Result != test.want {
+				t.Errorf("got %s, want %s", results[0].Result, test.want)
+			}
+		})
+	}
+}
+
+func TestExportThenables(t *testing.T) {
+	env := nodeEnvs(t)["worker"]
+	// awaited values are settled, the others stay promises
+	code := `await __jscomptime_export_value(0, Promise.resolve([1]))
+await __jscomptime_export_value(1, { then(resolve) { resolve(2) } })
+await __jscomptime_export_value(2, Promise.resolve(3), true)`
+	results := make([]EvalResult, 3)
+	_, err := env.Eval(context.Background(), Program{Code: code}, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Promise.resolve([1])", "Promise.resolve(2)", "3"}
+	for i := range want {
+		if results[i].Result != want[i] {
+			t.Errorf("result %d: got %s, want %s", i, results[i].Result, want[i])
+		}
+	}
+
+	_, err = env.Eval(context.Background(), Program{Code: `await Promise.reject(new Error("rejected"))`}, nil)
+	if err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("got error %v for a rejected await", err)
+	}
+}
@@ -6,12 +6,37 @@ import (
 	sitter "github.com/smacker/go-tree-sitter"
 )
 
-type Eval struct {
+type EvalResult struct {
 	Node   *sitter.Node
 	Result string
 }
 
-type Env interface {
-	Eval(ctx context.Context, code string, results []Eval) error
+type Program struct {
+	// the path of the source file the code was taken from, relative
+	// requires are resolved from it
+	Filename string
+	// the comptime code, it is executed in an async function so it can use
+	// await at its top level
+	Code string
+}
+
+// a module imported by the output, values exported by comptime code refer to
+// it by its binding
+type Import struct {
+	Binding   string `json:"binding"`
+	Specifier string `json:"specifier"`
+	// the name of the export that is imported, "*" for the namespace of the
+	// module and "default" for its default export
+	Name string `json:"name"`
 }
 
+type Evaluation struct {
+	// absolute paths of the files read by the comptime code
+	Dependencies []string
+	// the modules the exported values refer to
+	Imports []Import
+}
+
+type Env interface {
+	Eval(ctx context.Context, program Program, results []EvalResult) (Evaluation, error)
+}
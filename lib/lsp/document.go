@@ -0,0 +1,359 @@
+package lsp
+
+import (
+	"context"
+	"jscomptime/lib/comptime"
+	"jscomptime/lib/jsenv"
+	"net/url"
+	"sort"
+	"unicode/utf8"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+type document struct {
+	uri        string
+	path       string
+	text       []byte
+	lineStarts []int
+	opts       comptime.Options
+
+	analysis   *comptime.Analysis
+	inspection *comptime.Inspection
+	// the error returned while evaluating the comptime code
+	evalErr error
+}
+
+func pathFromURI(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "file" {
+		return uri
+	}
+	return parsed.Path
+}
+
+func newDocument(uri string, text string) *document {
+	path := pathFromURI(uri)
+	d := &document{
+		uri:  uri,
+		path: path,
+		text: []byte(text),
+		opts: comptime.Options{
+			Dialect:  comptime.DialectFromPath(path),
+			Filename: path,
+		},
+	}
+	d.lineStarts = []int{0}
+	for i, b := range d.text {
+		if b == '\n' {
+			d.lineStarts = append(d.lineStarts, i+1)
+		}
+	}
+	return d
+}
+
+// analyzes and evaluates the document
+func (d *document) update(ctx context.Context, env jsenv.Env) error {
+	parsed, err := comptime.Parse(ctx, d.text, d.opts)
+	if err != nil {
+		return err
+	}
+	parsed, err = comptime.Normalize(ctx, parsed, d.opts)
+	if err != nil {
+		return err
+	}
+	analysis := comptime.Analyze(parsed, d.opts)
+	d.analysis = analysis
+	d.inspection = nil
+	d.evalErr = nil
+	if comptime.HasErrors(analysis.Diagnostics) {
+		return nil
+	}
+
+	inspection, err := comptime.Inspect(ctx, analysis, env, d.opts)
+	if err != nil {
+		d.evalErr = err
+		return nil
+	}
+	d.inspection = &inspection
+	return nil
+}
+
+// converts a byte offset to an lsp position (with a utf-16 character offset)
+func (d *document) position(offset int) Position {
+	line := sort.Search(len(d.lineStarts), func(i int) bool {
+		return d.lineStarts[i] > offset
+	}) - 1
+	character := 0
+	for _, r := range string(d.text[d.lineStarts[line]:offset]) {
+		character++
+		if r >= 0x10000 {
+			character++
+		}
+	}
+	return Position{Line: line, Character: character}
+}
+
+// converts a byte offset to a tree-sitter point (with a byte column) in the
+// analyzed source, the normalization never inserts line breaks
+func (d *document) point(offset int) sitter.Point {
+	line := sort.Search(len(d.lineStarts), func(i int) bool {
+		return d.lineStarts[i] > offset
+	}) - 1
+	return sitter.Point{
+		Row:    uint32(line),
+		Column: uint32(d.analysis.NormalizedOffset(offset) - d.analysis.NormalizedOffset(d.lineStarts[line])),
+	}
+}
+
+// converts an lsp position to a byte offset
+func (d *document) offset(pos Position) int {
+	if pos.Line >= len(d.lineStarts) {
+		return len(d.text)
+	}
+	offset := d.lineStarts[pos.Line]
+	character := 0
+	for offset < len(d.text) && d.text[offset] != '\n' && character < pos.Character {
+		r, size := utf8.DecodeRune(d.text[offset:])
+		offset += size
+		character++
+		if r >= 0x10000 {
+			character++
+		}
+	}
+	return offset
+}
+
+// converts an offset in the analyzed source, which is normalized, to an
+// offset in the document
+func (d *document) documentOffset(offset int) int {
+	return d.analysis.OriginalOffset(offset)
+}
+
+func (d *document) nodeRange(node *sitter.Node) Range {
+	return Range{
+		Start: d.position(d.documentOffset(int(node.StartByte()))),
+		End:   d.position(d.documentOffset(int(node.EndByte()))),
+	}
+}
+
+func (d *document) diagnostics() []Diagnostic {
+	diagnostics := []Diagnostic{}
+	if d.analysis == nil {
+		return diagnostics
+	}
+	for _, diag := range d.analysis.Diagnostics {
+		severity := 1
+		if diag.Severity == comptime.SEVERITY_WARNING {
+			severity = 2
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Range: Range{
+				Start: d.position(d.documentOffset(int(diag.Start.Offset))),
+				End:   d.position(d.documentOffset(int(diag.End.Offset))),
+			},
+			Severity: severity,
+			Source:   "jscomptime",
+			Message:  diag.Message,
+		})
+	}
+	if d.evalErr != nil {
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: 1,
+			Source:   "jscomptime",
+			Message:  "comptime code failed: " + d.evalErr.Error(),
+		})
+	}
+	return diagnostics
+}
+
+func contains(node *sitter.Node, offset int) bool {
+	return int(node.StartByte()) <= offset && offset < int(node.EndByte())
+}
+
+// returns the identifier inserted as the value of a shorthand property
+// expanded by the normalization, nil if the node is not such a property
+func (d *document) expandedValue(node *sitter.Node) *sitter.Node {
+	if node == nil || node.Type() != "property_identifier" {
+		return nil
+	}
+	pair := node.Parent()
+	if pair == nil || pair.Type() != "pair" || pair.ChildByFieldName("key").StartByte() != node.StartByte() {
+		return nil
+	}
+	value := pair.ChildByFieldName("value")
+	if value == nil || value.Type() != "identifier" ||
+		d.documentOffset(int(value.StartByte())) != d.documentOffset(int(value.EndByte())) {
+		return nil
+	}
+	return value
+}
+
+func (d *document) hover(pos Position) *Hover {
+	if d.analysis == nil || d.inspection == nil {
+		return nil
+	}
+	point := d.point(d.offset(pos))
+	offset := d.analysis.NormalizedOffset(d.offset(pos))
+	// the node under the position, the range of the hover
+	target := d.analysis.Tree.RootNode().NamedDescendantForPointRange(point, point)
+	node := target
+	if value := d.expandedValue(target); value != nil {
+		// a shorthand property is hovered like the value inserted by its
+		// expansion
+		node = value
+		offset = int(value.StartByte())
+	}
+
+	// the innermost region containing the position
+	var region *comptime.InspectedValue
+	for i, r := range d.inspection.Regions {
+		if !contains(r.Node, offset) {
+			continue
+		}
+		if region == nil ||
+			r.Node.EndByte()-r.Node.StartByte() < region.Node.EndByte()-region.Node.StartByte() {
+			region = &d.inspection.Regions[i]
+		}
+	}
+	if region != nil {
+		r := d.nodeRange(region.Node)
+		if r.Start == r.End {
+			r = d.nodeRange(target)
+		}
+		return &Hover{
+			Contents: valueMarkdown("comptime region", region.Value),
+			Range:    &r,
+		}
+	}
+
+	if node == nil {
+		return nil
+	}
+	switch node.Type() {
+	case "identifier", "shorthand_property_identifier_pattern", "shorthand_property_identifier":
+	default:
+		return nil
+	}
+	name := node.Content(d.analysis.Source)
+	decl := comptime.ResolveDeclaration(name, comptime.ScopeAt(d.analysis.Root, uint32(offset)))
+	if decl == nil {
+		return nil
+	}
+
+	r := d.nodeRange(target)
+	value, ok := d.inspection.Bindings[decl.Node.StartByte()][name]
+	if !ok {
+		// functions and classes are not serialized
+		return &Hover{
+			Contents: MarkupContent{
+				Kind:  "markdown",
+				Value: "comptime `" + name + "`",
+			},
+			Range: &r,
+		}
+	}
+	return &Hover{
+		Contents: valueMarkdown("comptime `"+name+"`", value),
+		Range:    &r,
+	}
+}
+
+func valueMarkdown(title string, value string) MarkupContent {
+	return MarkupContent{
+		Kind: "markdown",
+		Value: title + "\n```js\n" +
+			comptime.FormatValue(value, 0, "", comptime.Options{}) +
+			"\n```",
+	}
+}
+
+const (
+	TOKEN_REGION = iota
+	TOKEN_LABEL
+)
+
+// the token types, in the order of the constants above
+var tokenTypes = []string{"macro", "keyword"}
+
+type token struct {
+	start     int
+	end       int
+	tokenType int
+}
+
+func collectTokens(scope *comptime.Scope, source []byte, tokens []token) []token {
+	label := func(body *sitter.Node) {
+		statement := body.Parent()
+		if statement == nil || statement.Type() != "labeled_statement" {
+			return
+		}
+		l := statement.ChildByFieldName("label")
+		tokens = append(tokens, token{int(l.StartByte()), int(l.EndByte()), TOKEN_LABEL})
+	}
+	for _, s := range scope.ComptimeStatements {
+		label(s)
+	}
+	for _, d := range scope.ComptimeDeclarations {
+		label(d.Node)
+	}
+	for _, r := range scope.Regions {
+		tokens = append(tokens, token{int(r.StartByte()), int(r.EndByte()), TOKEN_REGION})
+	}
+	for _, e := range scope.JSXExpansions {
+		tokens = append(tokens, token{int(e.Node.StartByte()), int(e.Node.EndByte()), TOKEN_REGION})
+	}
+	for _, m := range scope.Macros {
+		tokens = append(tokens, token{int(m.Node.StartByte()), int(m.Node.EndByte()), TOKEN_REGION})
+	}
+	for _, child := range scope.Scopes {
+		tokens = collectTokens(child, source, tokens)
+	}
+	return tokens
+}
+
+// returns the semantic tokens of the document encoded as relative integers
+func (d *document) semanticTokens() SemanticTokens {
+	data := []int{}
+	if d.analysis == nil {
+		return SemanticTokens{Data: data}
+	}
+
+	tokens := collectTokens(d.analysis.Root, d.analysis.Source, nil)
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].start < tokens[j].start
+	})
+
+	prev := Position{}
+	emit := func(start, end Position, tokenType int) {
+		if end.Character <= start.Character {
+			return
+		}
+		deltaStart := start.Character
+		if start.Line == prev.Line {
+			deltaStart -= prev.Character
+		}
+		data = append(data, start.Line-prev.Line, deltaStart, end.Character-start.Character, tokenType, 0)
+		prev = start
+	}
+
+	cursor := 0
+	for _, t := range tokens {
+		// tokens can't overlap
+		if t.start < cursor {
+			continue
+		}
+		cursor = t.end
+
+		start := d.position(d.documentOffset(t.start))
+		end := d.position(d.documentOffset(t.end))
+		// tokens can't span multiple lines either
+		for start.Line < end.Line {
+			lineEnd := d.position(d.lineStarts[start.Line+1] - 1)
+			emit(start, lineEnd, t.tokenType)
+			start = Position{Line: start.Line + 1}
+		}
+		emit(start, end, t.tokenType)
+	}
+	return SemanticTokens{Data: data}
+}
@@ -0,0 +1,109 @@
+package lsp
+
+import (
+	"context"
+	"jscomptime/lib/jsenv"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// returns an environment backed by node writing its files in a temporary
+// directory, the test is skipped if node is not installed
+func nodeEnv(t *testing.T) jsenv.Env {
+	t.Helper()
+	_, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return jsenv.Nodejs{Command: "node", Output: &strings.Builder{}}
+}
+
+func TestPosition(t *testing.T) {
+	d := newDocument("file:///a.js", "a\n😀b = 1\n")
+	tests := []struct {
+		offset   int
+		position Position
+	}{
+		{0, Position{0, 0}},
+		{2, Position{1, 0}},
+		// the emoji is two utf-16 code units and four bytes
+		{6, Position{1, 2}},
+		{12, Position{2, 0}},
+	}
+	for _, test := range tests {
+		if got := d.position(test.offset); got != test.position {
+			t.Errorf("position(%d): expected %v, got %v", test.offset, test.position, got)
+		}
+		if got := d.offset(test.position); got != test.offset {
+			t.Errorf("offset(%v): expected %d, got %d", test.position, test.offset, got)
+		}
+	}
+}
+
+func TestHover(t *testing.T) {
+	env := nodeEnv(t)
+	d := newDocument("file:///a.js", "$comptime: var port = 8080\nconst o = { port, w: window }, p = port + 1\n")
+	err := d.update(context.Background(), env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diagnostics := d.diagnostics(); len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+
+	tests := []struct {
+		name     string
+		position Position
+		value    string
+		hovered  Range
+	}{
+		{
+			// the value of the property is inserted by the normalization
+			name:     "shorthand property",
+			position: Position{1, 13},
+			value:    "8080",
+			hovered:  Range{Position{1, 12}, Position{1, 16}},
+		},
+		{
+			name:     "region after a shorthand property",
+			position: Position{1, 36},
+			value:    "8081",
+			hovered:  Range{Position{1, 35}, Position{1, 43}},
+		},
+		{
+			name:     "comptime declaration",
+			position: Position{0, 16},
+			value:    "8080",
+			hovered:  Range{Position{0, 15}, Position{0, 19}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hover := d.hover(test.position)
+			if hover == nil {
+				t.Fatal("expected a hover")
+			}
+			if !strings.Contains(hover.Contents.Value, test.value) {
+				t.Errorf("expected %s in %q", test.value, hover.Contents.Value)
+			}
+			if *hover.Range != test.hovered {
+				t.Errorf("expected the range %v, got %v", test.hovered, *hover.Range)
+			}
+		})
+	}
+
+	if hover := d.hover(Position{1, 6}); hover != nil {
+		t.Errorf("unexpected hover on a runtime identifier: %v", hover.Contents.Value)
+	}
+}
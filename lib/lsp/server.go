@@ -0,0 +1,186 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"jscomptime/lib/jsenv"
+)
+
+type Server struct {
+	conn      *conn
+	env       jsenv.Env
+	documents map[string]*document
+	shutdown  bool
+}
+
+func NewServer(input io.Reader, output io.Writer, env jsenv.Env) *Server {
+	return &Server{
+		conn:      newConn(input, output),
+		env:       env,
+		documents: map[string]*document{},
+	}
+}
+
+// handles messages until the client exits, an error is returned if the
+// client exits without shutting the server down first
+func (s *Server) Serve(ctx context.Context) error {
+	for {
+		msg, err := s.conn.read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exited without shutting down")
+			}
+			return nil
+		}
+
+		result, err := s.handle(ctx, msg)
+		if msg.Id == nil {
+			// notifications are not responded to
+			continue
+		}
+
+		response := message{Id: msg.Id}
+		if err != nil {
+			rpcErr := &responseError{Code: INTERNAL_ERROR, Message: err.Error()}
+			errors.As(err, &rpcErr)
+			response.Error = rpcErr
+		} else {
+			response.Result, err = json.Marshal(result)
+			if err != nil {
+				return err
+			}
+		}
+		err = s.conn.write(response)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (e *responseError) Error() string {
+	return e.Message
+}
+
+func (s *Server) publishDiagnostics(d *document) error {
+	return s.conn.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         d.uri,
+		Diagnostics: d.diagnostics(),
+	})
+}
+
+func (s *Server) open(ctx context.Context, uri string, text string) error {
+	d := newDocument(uri, text)
+	err := d.update(ctx, s.env)
+	if err != nil {
+		return err
+	}
+	s.documents[uri] = d
+	return s.publishDiagnostics(d)
+}
+
+func (s *Server) document(uri string) (*document, error) {
+	d, ok := s.documents[uri]
+	if !ok {
+		return nil, &responseError{
+			Code:    INVALID_PARAMS,
+			Message: fmt.Sprintf("unknown document: %s", uri),
+		}
+	}
+	return d, nil
+}
+
+func (s *Server) handle(ctx context.Context, msg message) (any, error) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				// full document sync
+				"textDocumentSync": 1,
+				"hoverProvider":    true,
+				"semanticTokensProvider": map[string]any{
+					"legend": map[string]any{
+						"tokenTypes":     tokenTypes,
+						"tokenModifiers": []string{},
+					},
+					"full": true,
+				},
+			},
+			"serverInfo": map[string]any{"name": "jscomptime"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		params := DidOpenParams{}
+		err := json.Unmarshal(msg.Params, &params)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.open(ctx, params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		params := DidChangeParams{}
+		err := json.Unmarshal(msg.Params, &params)
+		if err != nil {
+			return nil, err
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		return nil, s.open(ctx, params.TextDocument.URI, text)
+	case "textDocument/didClose":
+		params := DidCloseParams{}
+		err := json.Unmarshal(msg.Params, &params)
+		if err != nil {
+			return nil, err
+		}
+		delete(s.documents, params.TextDocument.URI)
+		return nil, s.conn.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []Diagnostic{},
+		})
+	case "textDocument/hover":
+		params := TextDocumentPositionParams{}
+		err := json.Unmarshal(msg.Params, &params)
+		if err != nil {
+			return nil, err
+		}
+		d, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return d.hover(params.Position), nil
+	case "textDocument/semanticTokens/full":
+		params := SemanticTokensParams{}
+		err := json.Unmarshal(msg.Params, &params)
+		if err != nil {
+			return nil, err
+		}
+		d, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return d.semanticTokens(), nil
+	case "initialized", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+	}
+
+	if msg.Id == nil {
+		// unknown notifications are ignored
+		return nil, nil
+	}
+	return nil, &responseError{
+		Code:    METHOD_NOT_FOUND,
+		Message: fmt.Sprintf("method not found: %s", msg.Method),
+	}
+}
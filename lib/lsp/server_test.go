@@ -0,0 +1,113 @@
+package lsp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// encodes messages with the base protocol, requests have an id
+func encodeMessages(t *testing.T, messages ...map[string]any) *bytes.Buffer {
+	t.Helper()
+	buff := &bytes.Buffer{}
+	for _, m := range messages {
+		m["jsonrpc"] = "2.0"
+		body, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(buff, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	return buff
+}
+
+func TestServe(t *testing.T) {
+	env := nodeEnv(t)
+	uri := "file:///a.js"
+	document := map[string]any{"uri": uri}
+	input := encodeMessages(
+		t,
+		map[string]any{"id": 1, "method": "initialize", "params": map[string]any{}},
+		map[string]any{"method": "initialized", "params": map[string]any{}},
+		map[string]any{"method": "textDocument/didOpen", "params": map[string]any{
+			"textDocument": map[string]any{"uri": uri, "version": 1, "text": "$comptime: var a = 1\nf(a + 1)\n"},
+		}},
+		map[string]any{"id": 2, "method": "textDocument/hover", "params": map[string]any{
+			"textDocument": document,
+			"position":     Position{Line: 1, Character: 2},
+		}},
+		map[string]any{"id": 3, "method": "textDocument/semanticTokens/full", "params": map[string]any{
+			"textDocument": document,
+		}},
+		map[string]any{"id": 4, "method": "textDocument/hover", "params": map[string]any{
+			"textDocument": map[string]any{"uri": "file:///unknown.js"},
+			"position":     Position{},
+		}},
+		map[string]any{"id": 5, "method": "shutdown"},
+		map[string]any{"method": "exit"},
+	)
+	output := &bytes.Buffer{}
+	err := NewServer(input, output, env).Serve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responses := map[string]message{}
+	notifications := []message{}
+	c := newConn(output, nil)
+	for {
+		msg, err := c.read()
+		if err != nil {
+			break
+		}
+		if msg.Id == nil {
+			notifications = append(notifications, msg)
+			continue
+		}
+		responses[string(*msg.Id)] = msg
+	}
+
+	if len(responses) != 5 {
+		t.Fatalf("expected 5 responses, got %d", len(responses))
+	}
+	if !strings.Contains(string(responses["1"].Result), `"hoverProvider":true`) {
+		t.Errorf("unexpected capabilities: %s", responses["1"].Result)
+	}
+	if len(notifications) != 1 || notifications[0].Method != "textDocument/publishDiagnostics" {
+		t.Errorf("expected the diagnostics of the document, got %v", notifications)
+	}
+
+	hover := Hover{}
+	err = json.Unmarshal(responses["2"].Result, &hover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(hover.Contents.Value, "\n2\n") {
+		t.Errorf("unexpected hover: %s", hover.Contents.Value)
+	}
+
+	tokens := SemanticTokens{}
+	err = json.Unmarshal(responses["3"].Result, &tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the label and the region, 5 integers each
+	if len(tokens.Data) != 10 {
+		t.Errorf("unexpected tokens: %v", tokens.Data)
+	}
+
+	if responses["4"].Error == nil || responses["4"].Error.Code != INVALID_PARAMS {
+		t.Errorf("expected an error for an unknown document, got %s", responses["4"].Result)
+	}
+}
+
+func TestServeExitWithoutShutdown(t *testing.T) {
+	input := encodeMessages(t, map[string]any{"method": "exit"})
+	err := NewServer(input, &bytes.Buffer{}, nil).Serve(context.Background())
+	if err == nil {
+		t.Error("expected an error")
+	}
+}